@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// apiError is the body of every JSON error response.
+type apiError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+func writeJSONError(w http.ResponseWriter, status int, format string, args ...any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: fmt.Sprintf(format, args...), Status: status})
+}
+
+// jsonMuxErrors makes the 404 and 405 responses mux generates for
+// requests matching no route JSON errors like the handlers' own. The
+// Allow header of a 405 is kept.
+func jsonMuxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(&muxErrorWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+// muxErrorWriter replaces the plain-text body of an error written by
+// ServeMux with a JSON one.
+type muxErrorWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	handled bool
+}
+
+func (w *muxErrorWriter) WriteHeader(status int) {
+	if status < http.StatusBadRequest {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.handled = true
+	switch status {
+	case http.StatusNotFound:
+		writeJSONError(w.ResponseWriter, status, "no endpoint %s", w.r.URL.Path)
+	case http.StatusMethodNotAllowed:
+		writeJSONError(w.ResponseWriter, status, "method %s not allowed for %s", w.r.Method, w.r.URL.Path)
+	default:
+		writeJSONError(w.ResponseWriter, status, "%s", strings.ToLower(http.StatusText(status)))
+	}
+}
+
+func (w *muxErrorWriter) Write(b []byte) (int, error) {
+	if w.handled {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// sensorFields are the fields selectable with ?fields=. Only id and
+// value are returned by default.
+var sensorFields = []string{"id", "value", "name", "unit", "kind", "location", "address", "model"}
+
+// sensorKind is the quantity a sensor measures, e.g. "temperature".
+func sensorKind(s Sensor) string {
+	if meta, ok := sensorMetaMap[s.ID]; ok && meta.DeviceClass != "" {
+		return meta.DeviceClass
+	}
+	if strings.HasSuffix(s.ID, "humidity") {
+		return "humidity"
+	}
+	return "temperature"
+}
+
+func sensorField(s Sensor, field string) string {
+	meta := sensorMetaMap[s.ID]
+	switch field {
+	case "id":
+		return s.ID
+	case "value":
+		return s.Value
+	case "name":
+		return meta.FriendlyName
+	case "unit":
+		return meta.Unit
+	case "kind":
+		return sensorKind(s)
+	case "location":
+		return meta.Location
+	case "address":
+		return s.Address
+	case "model":
+		return s.Model
+	}
+	return ""
+}
+
+// queryList returns the comma-separated values of all occurrences of
+// a query parameter.
+func queryList(r *http.Request, name string) []string {
+	var list []string
+	for _, v := range r.URL.Query()[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// sensorQuery is a parsed /sensors query: filters on id, kind and
+// location (each a list of accepted values) and the fields to return.
+type sensorQuery struct {
+	ids, kinds, locations []string
+	fields                []string // nil: id and value only
+}
+
+func parseSensorQuery(r *http.Request) (sensorQuery, error) {
+	q := sensorQuery{
+		ids:       queryList(r, "id"),
+		kinds:     queryList(r, "kind"),
+		locations: queryList(r, "location"),
+		fields:    queryList(r, "fields"),
+	}
+	for _, f := range q.fields {
+		if !slices.Contains(sensorFields, f) {
+			return q, fmt.Errorf("unknown field %q (available: %s)", f, strings.Join(sensorFields, ", "))
+		}
+	}
+	return q, nil
+}
+
+func (q sensorQuery) match(s Sensor) bool {
+	if len(q.ids) > 0 && !slices.Contains(q.ids, s.ID) {
+		return false
+	}
+	if len(q.kinds) > 0 && !slices.ContainsFunc(q.kinds, func(k string) bool { return strings.EqualFold(k, sensorKind(s)) }) {
+		return false
+	}
+	loc := sensorMetaMap[s.ID].Location
+	if len(q.locations) > 0 && !slices.ContainsFunc(q.locations, func(l string) bool { return strings.EqualFold(l, loc) }) {
+		return false
+	}
+	return true
+}
+
+// render returns s as it is encoded in responses: the Sensor itself,
+// or a map of the selected fields.
+func (q sensorQuery) render(s Sensor) any {
+	if q.fields == nil {
+		return s
+	}
+	m := make(map[string]string, len(q.fields))
+	for _, f := range q.fields {
+		m[f] = sensorField(s, f)
+	}
+	return m
+}
+
+// sensorResponse is the /sensors body; each entry is a Sensor or, with
+// ?fields=, a map of the selected fields.
+type sensorResponse struct {
+	Sensors []any `json:"sensors"`
+}
+
+// handleSensors returns the cached readings, optionally filtered with
+// ?id=, ?kind= and ?location= and reduced to ?fields=, in the format
+// negotiated by negotiateFormat. Responses carry the poll's ETag and
+// Last-Modified and can be cached until the next poll.
+func (s *server) handleSensors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	q, format, ok := parseSensorRequest(w, r, false)
+	if !ok {
+		return
+	}
+	snap, ok := s.requestSnapshot(w, r)
+	if !ok {
+		return
+	}
+	if !s.writeCacheHeaders(w, r, snap) {
+		return
+	}
+	writeSensorList(w, format, q, snap.Sensors)
+}
+
+// writeSensorList writes the sensors matching q.
+func writeSensorList(w http.ResponseWriter, format string, q sensorQuery, sensors []Sensor) {
+	var matched []Sensor
+	for _, sn := range sensors {
+		if q.match(sn) {
+			matched = append(matched, sn)
+		}
+	}
+	if format != formatJSON {
+		writeSensors(w, format, q, matched)
+		return
+	}
+	list := []any{}
+	for _, sn := range matched {
+		list = append(list, q.render(sn))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sensorResponse{Sensors: list})
+}
+
+// parseSensorRequest parses the query and format, replying with an
+// error if either is invalid.
+func parseSensorRequest(w http.ResponseWriter, r *http.Request, single bool) (sensorQuery, string, bool) {
+	q, err := parseSensorQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "%v", err)
+		return q, "", false
+	}
+	format, err := negotiateFormat(r, single)
+	if err != nil {
+		status := http.StatusNotAcceptable
+		if r.URL.Query().Has("format") {
+			status = http.StatusBadRequest
+		}
+		writeJSONError(w, status, "%v", err)
+		return q, "", false
+	}
+	return q, format, true
+}
+
+// handleSensor returns the reading of a single sensor.
+func (s *server) handleSensor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	q, format, ok := parseSensorRequest(w, r, true)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	snap, ok := s.requestSnapshot(w, r)
+	if !ok {
+		return
+	}
+	sensors := snap.Sensors
+	i := slices.IndexFunc(sensors, func(sn Sensor) bool { return sn.ID == id })
+	if i < 0 {
+		writeJSONError(w, http.StatusNotFound, "sensor %q not found", id)
+		return
+	}
+	if !s.writeCacheHeaders(w, r, snap) {
+		return
+	}
+	if format != formatJSON {
+		writeSensors(w, format, q, sensors[i:i+1])
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q.render(sensors[i]))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newAPIServer(sensors []Sensor) (*server, *http.ServeMux) {
+	srv := &server{}
+	srv.store(sensors)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sensors", srv.handleSensors)
+	mux.HandleFunc("GET /sensors/{id}", srv.handleSensor)
+	return srv, mux
+}
+
+func get(t *testing.T, h http.Handler, target string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s: content-type = %q", target, ct)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("%s: decode: %v", target, err)
+	}
+	return rec.Code
+}
+
+var apiSensors = []Sensor{
+	{ID: "hot_water_middle", Value: "48.750", Address: "28-000000000001", Model: "DS18B20"},
+	{ID: "heating_flow", Value: "55.000"},
+	{ID: "utility_room_temperature", Value: "21.3"},
+	{ID: "utility_room_humidity", Value: "49.3"},
+}
+
+func TestHandleSensors_Filters(t *testing.T) {
+	_, mux := newAPIServer(apiSensors)
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"hot_water_middle", "heating_flow", "utility_room_temperature", "utility_room_humidity"}},
+		{"?id=heating_flow,hot_water_middle", []string{"hot_water_middle", "heating_flow"}},
+		{"?id=heating_flow&id=utility_room_humidity", []string{"heating_flow", "utility_room_humidity"}},
+		{"?kind=humidity", []string{"utility_room_humidity"}},
+		{"?location=utility_room&kind=temperature", []string{"utility_room_temperature"}},
+		{"?location=garage", []string{}},
+	}
+	for _, c := range cases {
+		var resp struct {
+			Sensors []Sensor `json:"sensors"`
+		}
+		if code := get(t, mux, "/sensors"+c.query, &resp); code != http.StatusOK {
+			t.Errorf("%s: status = %d", c.query, code)
+		}
+		if resp.Sensors == nil {
+			t.Errorf("%s: sensors is null", c.query)
+		}
+		var got []string
+		for _, s := range resp.Sensors {
+			got = append(got, s.ID)
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.query, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: got %v, want %v", c.query, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestHandleSensors_Fields(t *testing.T) {
+	_, mux := newAPIServer(apiSensors)
+
+	var resp struct {
+		Sensors []map[string]string `json:"sensors"`
+	}
+	get(t, mux, "/sensors?id=hot_water_middle&fields=id,unit,location,model", &resp)
+	want := map[string]string{"id": "hot_water_middle", "unit": "°C", "location": "hot_water_tank", "model": "DS18B20"}
+	if len(resp.Sensors) != 1 || len(resp.Sensors[0]) != len(want) {
+		t.Fatalf("sensors = %v", resp.Sensors)
+	}
+	for k, v := range want {
+		if resp.Sensors[0][k] != v {
+			t.Errorf("%s = %q, want %q", k, resp.Sensors[0][k], v)
+		}
+	}
+
+	var apiErr apiError
+	if code := get(t, mux, "/sensors?fields=id,colour", &apiErr); code != http.StatusBadRequest || apiErr.Status != 400 || apiErr.Error == "" {
+		t.Errorf("unknown field: status = %d, body = %+v", code, apiErr)
+	}
+}
+
+func TestHandleSensor(t *testing.T) {
+	_, mux := newAPIServer(apiSensors)
+
+	var s Sensor
+	if code := get(t, mux, "/sensors/heating_flow", &s); code != http.StatusOK || s.ID != "heating_flow" || s.Value != "55.000" {
+		t.Errorf("status = %d, sensor = %+v", code, s)
+	}
+
+	var fields map[string]string
+	get(t, mux, "/sensors/utility_room_humidity?fields=value,kind,unit", &fields)
+	if fields["value"] != "49.3" || fields["kind"] != "humidity" || fields["unit"] != "%" {
+		t.Errorf("fields = %v", fields)
+	}
+
+	var apiErr apiError
+	if code := get(t, mux, "/sensors/garage", &apiErr); code != http.StatusNotFound ||
+		apiErr.Error != `sensor "garage" not found` || apiErr.Status != 404 {
+		t.Errorf("unknown sensor: status = %d, body = %+v", code, apiErr)
+	}
+}
+
+func TestJSONMuxErrors(t *testing.T) {
+	_, mux := newAPIServer(apiSensors)
+	h := jsonMuxErrors(mux)
+
+	cases := []struct {
+		method, target string
+		status         int
+		body, allow    string
+	}{
+		{"POST", "/sensors/hot_water_middle", 405,
+			"{\"error\":\"method POST not allowed for /sensors/hot_water_middle\",\"status\":405}\n", "GET, HEAD"},
+		{"GET", "/sensorz", 404, "{\"error\":\"no endpoint /sensorz\",\"status\":404}\n", ""},
+		// Errors from handlers pass through unchanged.
+		{"GET", "/sensors/garage", 404, "{\"error\":\"sensor \\\"garage\\\" not found\",\"status\":404}\n", ""},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(c.method, c.target, nil))
+		if rec.Code != c.status || rec.Header().Get("Content-Type") != "application/json" ||
+			rec.Body.String() != c.body || rec.Header().Get("Allow") != c.allow {
+			t.Errorf("%s %s: %d %q Allow %q\n%s", c.method, c.target, rec.Code,
+				rec.Header().Get("Content-Type"), rec.Header().Get("Allow"), rec.Body)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold  = 3
+	defaultBreakerMinBackoff = 10 * time.Second
+	defaultBreakerMaxBackoff = 10 * time.Minute
+	defaultBreakerJitter     = 0.2
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops calls to a failing destination. After
+// threshold consecutive failures it opens and rejects calls for a
+// backoff period that doubles (with jitter) after every failed probe,
+// up to maxBackoff. When the period ends it lets a single probe
+// through (half-open); success closes it again.
+type circuitBreaker struct {
+	name       string // log prefix
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	jitter     float64 // fraction of the backoff added or subtracted at random
+
+	mu             sync.Mutex
+	state          breakerState
+	failures       int
+	backoff        time.Duration
+	openUntil      time.Time
+	opened         uint64
+	rejected       uint64
+	transitions    uint64
+	lastTransition time.Time
+}
+
+type breakerStatus struct {
+	State          string     `json:"state"`
+	Failures       int        `json:"consecutive_failures"`
+	Opened         uint64     `json:"opened"`
+	Rejected       uint64     `json:"rejected"`
+	Transitions    uint64     `json:"transitions"`
+	NextProbe      *time.Time `json:"next_probe,omitempty"`
+	LastTransition *time.Time `json:"last_transition,omitempty"`
+}
+
+func newCircuitBreaker(name string) *circuitBreaker {
+	return &circuitBreaker{
+		name:       name,
+		threshold:  defaultBreakerThreshold,
+		minBackoff: defaultBreakerMinBackoff,
+		maxBackoff: defaultBreakerMaxBackoff,
+		jitter:     defaultBreakerJitter,
+	}
+}
+
+// Allow reports whether a call may proceed. While half-open only the
+// first caller is let through until its result is recorded.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.openUntil) {
+			b.rejected++
+			return false
+		}
+		b.transition(breakerHalfOpen)
+		log.Printf("%s: circuit half-open, probing", b.name)
+		return true
+	case breakerHalfOpen:
+		b.rejected++
+		return false
+	}
+	return true
+}
+
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state != breakerClosed {
+		b.transition(breakerClosed)
+		b.backoff = 0
+		log.Printf("%s: circuit closed", b.name)
+	}
+}
+
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	switch b.state {
+	case breakerClosed:
+		if b.failures < b.threshold {
+			return
+		}
+		b.backoff = b.minBackoff
+	case breakerHalfOpen:
+		b.backoff = min(b.backoff*2, b.maxBackoff)
+	default:
+		return
+	}
+
+	wait := b.backoff
+	if b.jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * b.jitter * float64(b.backoff))
+	}
+	b.openUntil = time.Now().Add(wait)
+	b.opened++
+	b.transition(breakerOpen)
+	log.Printf("%s: circuit open after %d consecutive failures, next probe in %s",
+		b.name, b.failures, wait.Round(time.Second))
+}
+
+// transition switches state; the caller holds b.mu.
+func (b *circuitBreaker) transition(to breakerState) {
+	b.state = to
+	b.transitions++
+	b.lastTransition = time.Now()
+}
+
+func (b *circuitBreaker) Status() breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st := breakerStatus{
+		State:       b.state.String(),
+		Failures:    b.failures,
+		Opened:      b.opened,
+		Rejected:    b.rejected,
+		Transitions: b.transitions,
+	}
+	if b.state == breakerOpen {
+		t := b.openUntil
+		st.NextProbe = &t
+	}
+	if !b.lastTransition.IsZero() {
+		t := b.lastTransition
+		st.LastTransition = &t
+	}
+	return st
+}
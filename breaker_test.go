@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_Transitions(t *testing.T) {
+	b := newCircuitBreaker("test")
+	b.threshold = 2
+	b.minBackoff = 20 * time.Millisecond
+	b.jitter = 0
+
+	b.Failure()
+	if !b.Allow() {
+		t.Fatal("breaker rejected below threshold")
+	}
+	b.Failure()
+	if st := b.Status(); st.State != "open" || st.Opened != 1 || st.NextProbe == nil {
+		t.Fatalf("status = %+v, want open", st)
+	}
+	if b.Allow() {
+		t.Error("open breaker allowed a call")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker did not allow a probe after backoff")
+	}
+	if b.Allow() {
+		t.Error("half-open breaker allowed a second concurrent probe")
+	}
+
+	// A failed probe reopens with twice the backoff.
+	b.Failure()
+	if b.backoff != 40*time.Millisecond {
+		t.Errorf("backoff = %s, want 40ms", b.backoff)
+	}
+	time.Sleep(25 * time.Millisecond)
+	if b.Allow() {
+		t.Error("breaker probed before the doubled backoff elapsed")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker did not allow a probe after doubled backoff")
+	}
+	b.Success()
+	if st := b.Status(); st.State != "closed" || st.Failures != 0 || st.Rejected != 3 || st.Transitions != 5 {
+		t.Errorf("status = %+v, want closed with 3 rejected after 5 transitions", st)
+	}
+}
+
+func TestCircuitBreaker_BackoffCapped(t *testing.T) {
+	b := newCircuitBreaker("test")
+	b.threshold = 1
+	b.minBackoff = time.Millisecond
+	b.maxBackoff = 3 * time.Millisecond
+	b.jitter = 0
+
+	b.Failure()
+	for i := 0; i < 4; i++ {
+		time.Sleep(b.backoff + time.Millisecond)
+		if !b.Allow() {
+			t.Fatalf("probe %d rejected", i)
+		}
+		b.Failure()
+	}
+	if b.backoff != 3*time.Millisecond {
+		t.Errorf("backoff = %s, want capped at 3ms", b.backoff)
+	}
+}
+
+func TestHAPusher_BreakerStopsRequests(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	p := NewHAPusher(ts.URL, "test-token")
+	p.breaker.threshold = 2
+	p.breaker.minBackoff = time.Hour
+
+	for _, v := range []string{"41", "42", "43", "44"} {
+		p.Push([]Sensor{{ID: "hot_water_middle", Value: v}})
+	}
+
+	// Push 1 fails, push 2 replays the queue, fails and opens the
+	// circuit; pushes 3 and 4 are queued without a request.
+	if n := requests.Load(); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+	if n := p.Backlog(); n != 4 {
+		t.Errorf("backlog = %d, want 4", n)
+	}
+	if st := p.BreakerStatus(); st.State != "open" || st.Rejected != 2 {
+		t.Errorf("breaker = %+v, want open with 2 rejected", st)
+	}
+}
+
+func TestHAPusher_ConcurrentEntities(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	p := NewHAPusher(ts.URL, "test-token")
+	start := time.Now()
+	err := p.Push([]Sensor{
+		{ID: "hot_water_middle", Value: "48.750"},
+		{ID: "heating_supply", Value: "42.500"},
+		{ID: "hot_water_bottom", Value: "45.000"},
+		{ID: "heating_return", Value: "38.125"},
+	})
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("push took %s, want entities sent concurrently", elapsed)
+	}
+}
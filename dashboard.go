@@ -0,0 +1,83 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed dashboard/index.html
+var dashboardFS embed.FS
+
+// handleDashboard serves the single-page dashboard. It only talks to
+// this server (/history, /events and /outputs), so it works without
+// internet access.
+func (s *server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	page, err := dashboardFS.ReadFile("dashboard/index.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(page)
+}
+
+type historyResponse struct {
+	PollInterval string          `json:"poll_interval"`
+	Generation   uint64          `json:"generation"`
+	Sensors      []sensorHistory `json:"sensors"`
+}
+
+type sensorHistory struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name,omitempty"`
+	Unit     string         `json:"unit,omitempty"`
+	Value    string         `json:"value"`
+	LastSeen time.Time      `json:"last_seen"`
+	Points   []historyPoint `json:"points"`
+}
+
+// historyPoint is a [unix milliseconds, value] pair.
+type historyPoint [2]float64
+
+// handleHistory returns the retained poll results (see eventHub) as a
+// series per sensor, with the friendly name and unit from the entity
+// config. LastSeen is the time of the last poll that included the
+// sensor, so sensors that dropped off the bus show up as stale.
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	history := s.events.History()
+	resp := historyResponse{PollInterval: s.pollInterval.String(), Sensors: []sensorHistory{}}
+
+	byID := make(map[string]*sensorHistory)
+	for _, ev := range history {
+		resp.Generation = ev.Generation
+		for _, sn := range ev.Sensors {
+			h, ok := byID[sn.ID]
+			if !ok {
+				h = &sensorHistory{ID: sn.ID}
+				if meta, ok := sensorMetaMap[sn.ID]; ok {
+					h.Name, h.Unit = meta.FriendlyName, meta.Unit
+				}
+				byID[sn.ID] = h
+			}
+			h.Value, h.LastSeen = sn.Value, ev.Time
+			if v, err := strconv.ParseFloat(sn.Value, 64); err == nil {
+				h.Points = append(h.Points, historyPoint{float64(ev.Time.UnixMilli()), v})
+			}
+		}
+	}
+	for _, h := range byID {
+		if h.Points == nil {
+			h.Points = []historyPoint{}
+		}
+		resp.Sensors = append(resp.Sensors, *h)
+	}
+	sort.Slice(resp.Sensors, func(i, j int) bool { return resp.Sensors[i].ID < resp.Sensors[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleDashboard(t *testing.T) {
+	srv := &server{events: newEventHub()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", srv.handleDashboard)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("status = %d, content-type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	// The Pi's LAN has no internet access: everything must be inline.
+	body := rec.Body.String()
+	for _, ref := range []string{"http://", "https://", "//cdn", "src="} {
+		if strings.Contains(body, ref) {
+			t.Errorf("dashboard references external asset (%q)", ref)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/nope status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	srv := &server{events: newEventHub(), pollInterval: 10 * time.Second}
+	publish(srv.events, []Sensor{{ID: "hot_water_middle", Value: "48.750"}, {ID: "garage", Value: "bogus"}})
+	publish(srv.events, []Sensor{{ID: "hot_water_middle", Value: "49.000"}})
+
+	rec := httptest.NewRecorder()
+	srv.handleHistory(rec, httptest.NewRequest("GET", "/history", nil))
+	var resp historyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.PollInterval != "10s" || resp.Generation != 2 || len(resp.Sensors) != 2 {
+		t.Fatalf("resp = %+v", resp)
+	}
+
+	garage, hw := resp.Sensors[0], resp.Sensors[1]
+	if hw.ID != "hot_water_middle" || hw.Name != "Warmwasser Mitte" || hw.Unit != "°C" ||
+		hw.Value != "49.000" || len(hw.Points) != 2 || hw.Points[1][1] != 49 {
+		t.Errorf("hot_water_middle = %+v", hw)
+	}
+	// Missing from the latest poll: last seen stays at the first one.
+	if garage.ID != "garage" || len(garage.Points) != 0 || garage.LastSeen.After(hw.LastSeen) {
+		t.Errorf("garage = %+v", garage)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// device is a physical device found on one of the buses, as listed by
+// GET /devices.
+type device struct {
+	Bus     string `json:"bus"`
+	Address string `json:"address"`
+	Family  string `json:"family,omitempty"` // 1-Wire family code
+	Model   string `json:"model"`
+	Driver  string `json:"driver"`
+	Channel string `json:"channel,omitempty"` // IIO channel, for devices with several readings
+	// ID is the ID the device's readings are served under; it is empty
+	// for devices that are not read. Mapped is true if it comes from
+	// SENSOR_MAP.
+	ID         string       `json:"id,omitempty"`
+	Mapped     bool         `json:"mapped"`
+	Value      string       `json:"value,omitempty"` // from the last poll
+	Resolution int          `json:"resolution,omitempty"`
+	Power      string       `json:"power,omitempty"` // "external" or "parasitic", if the kernel reports it
+	LastError  *deviceError `json:"last_error,omitempty"`
+}
+
+type deviceError struct {
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+	Count int       `json:"count"`
+}
+
+type devicesResponse struct {
+	Devices []device `json:"devices"`
+}
+
+// w1Slaves returns the device directories of every 1-Wire slave on the
+// bus, whatever its family, sorted by address. Bus masters
+// (w1_bus_master*) do not match.
+func w1Slaves(basePath string) []string {
+	pattern := filepath.Join(basePath, "[0-9a-f][0-9a-f]-*")
+	dirs, err := filepath.Glob(pattern)
+	if err != nil {
+		log.Printf("error globbing %s: %v", pattern, err)
+		return nil
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// w1Bus returns the name of the bus master a slave hangs off. In sysfs
+// the entries under /sys/bus/w1/devices are links into the master's
+// directory.
+func w1Bus(dir string) string {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	return filepath.Base(filepath.Dir(dir))
+}
+
+// readW1Power reads the ext_power attribute of w1_therm: "1" if the
+// device has its own supply, "0" if it draws parasitic power from the
+// data line. It returns "" if the kernel does not provide it.
+func readW1Power(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "ext_power"))
+	if err != nil {
+		return ""
+	}
+	switch strings.TrimSpace(string(data)) {
+	case "1":
+		return "external"
+	case "0":
+		return "parasitic"
+	}
+	return ""
+}
+
+// lastDeviceError returns the last read error for a sensor ID, if any.
+func lastDeviceError(id string) *deviceError {
+	if id == "" {
+		return nil
+	}
+	e, ok := readErrors.Get(id)
+	if !ok {
+		return nil
+	}
+	return &deviceError{Error: e.Err, At: e.At, Count: e.Count}
+}
+
+// devices lists every 1-Wire slave and the IIO device, with the values
+// from the last poll. Only DS18B20s are read; other 1-Wire families
+// are listed so they can be identified, but have no value.
+func (s *server) devices() []device {
+	byID := make(map[string]Sensor)
+	for _, sn := range s.sensors() {
+		byID[sn.ID] = sn
+	}
+
+	probes := make(map[string]w1Probe)
+	for _, p := range w1Probes(s.w1Path, s.sensorMap) {
+		probes[p.Address] = p
+	}
+
+	devices := []device{}
+	for _, dir := range w1Slaves(s.w1Path) {
+		addr := filepath.Base(dir)
+		family, _, _ := strings.Cut(addr, "-")
+		d := device{
+			Bus:        w1Bus(dir),
+			Address:    addr,
+			Family:     family,
+			Model:      w1Model(addr),
+			Driver:     "w1",
+			Resolution: readW1Resolution(dir),
+			Power:      readW1Power(dir),
+		}
+		if p, ok := probes[addr]; ok {
+			d.ID, d.Mapped = p.ID, p.Mapped
+		} else if id, ok := s.sensorMap[addr]; ok {
+			d.ID, d.Mapped = id, true
+		}
+		if d.ID != "" {
+			d.Value = byID[d.ID].Value
+			d.LastError = lastDeviceError(d.ID)
+		}
+		devices = append(devices, d)
+	}
+
+	if s.iioPath != "" {
+		for _, ch := range []struct{ channel, id string }{
+			{"temp", "utility_room_temperature"},
+			{"humidityrelative", "utility_room_humidity"},
+		} {
+			devices = append(devices, device{
+				Bus:       "iio",
+				Address:   filepath.Base(s.iioPath),
+				Model:     "DHT22",
+				Driver:    "iio",
+				Channel:   ch.channel,
+				ID:        ch.id,
+				Value:     byID[ch.id].Value,
+				LastError: lastDeviceError(ch.id),
+			})
+		}
+	}
+	return devices
+}
+
+// handleDevices lists every device found on the buses, so new probes
+// can be mapped without logging in to the Pi.
+func (s *server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(devicesResponse{Devices: s.devices()})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDevices(t *testing.T) {
+	bus := filepath.Join(t.TempDir(), "w1_bus_master1")
+	writeSlave := func(addr string, files map[string]string) {
+		dir := filepath.Join(bus, addr)
+		os.MkdirAll(dir, 0755)
+		for name, content := range files {
+			os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		}
+	}
+	slave := "33 00 4b 46 ff ff 02 10 f4 : crc=f4 YES\n33 00 4b 46 ff ff 02 10 f4 t=48750\n"
+	writeSlave("28-000000000001", map[string]string{"w1_slave": slave, "resolution": "12\n", "ext_power": "1\n"})
+	writeSlave("28-000000000002", map[string]string{"w1_slave": slave, "ext_power": "0\n"})
+	writeSlave("3a-000000000003", nil) // a DS2413 switch: listed, not read
+	os.WriteFile(filepath.Join(bus, "w1_master_slaves"), nil, 0644)
+
+	srv := &server{
+		w1Path:    bus,
+		iioPath:   "testdata/iio_device",
+		sensorMap: map[string]string{"28-000000000001": "hot_water_middle"},
+	}
+	srv.store(ReadAll(srv.w1Path, srv.iioPath, srv.sensorMap))
+	readErrors.record("1", errors.New("CRC check failed"))
+
+	rec := httptest.NewRecorder()
+	srv.handleDevices(rec, httptest.NewRequest("GET", "/devices", nil))
+	var resp devicesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Devices) != 5 {
+		t.Fatalf("devices = %+v", resp.Devices)
+	}
+
+	mapped := resp.Devices[0]
+	if mapped.Bus != "w1_bus_master1" || mapped.Address != "28-000000000001" || mapped.Family != "28" ||
+		mapped.Model != "DS18B20" || mapped.Driver != "w1" || mapped.ID != "hot_water_middle" || !mapped.Mapped ||
+		mapped.Value != "48.750" || mapped.Resolution != 12 || mapped.Power != "external" || mapped.LastError != nil {
+		t.Errorf("mapped = %+v", mapped)
+	}
+	unmapped := resp.Devices[1]
+	if unmapped.ID != "1" || unmapped.Mapped || unmapped.Power != "parasitic" || unmapped.Value != "48.750" ||
+		unmapped.LastError == nil || unmapped.LastError.Error != "CRC check failed" {
+		t.Errorf("unmapped = %+v", unmapped)
+	}
+	other := resp.Devices[2]
+	if other.Model != "family 3a" || other.ID != "" || other.Value != "" || other.Power != "" {
+		t.Errorf("other family = %+v", other)
+	}
+	dht := resp.Devices[3]
+	if dht.Bus != "iio" || dht.Model != "DHT22" || dht.Channel != "temp" || dht.ID != "utility_room_temperature" || dht.Value != "21.3" {
+		t.Errorf("dht22 = %+v", dht)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEventHistory     = 64
+	defaultEventSubscribers = 32
+	defaultEventHeartbeat   = 15 * time.Second
+	eventSubscriberBuffer   = 8
+)
+
+// pollEvent is the result of one poll. Generation is that of the
+// poll's snapshot, so the SSE event ID, X-Poll-Generation and ?since=
+// all count the same polls.
+type pollEvent struct {
+	Generation uint64         `json:"generation"`
+	Time       time.Time      `json:"time"`
+	Sensors    []Sensor       `json:"sensors"`
+	Changes    []sensorChange `json:"-"` // sensors that are new or changed value
+}
+
+type sensorChange struct {
+	ID       string `json:"id"`
+	Value    string `json:"value"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// eventHub broadcasts poll results to live subscribers and keeps the
+// most recent ones so clients can resume after a reconnect.
+type eventHub struct {
+	historySize int
+	maxSubs     int
+
+	mu      sync.Mutex
+	history []pollEvent
+	subs    map[chan pollEvent]struct{}
+	closed  bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		historySize: defaultEventHistory,
+		maxSubs:     defaultEventSubscribers,
+		subs:        make(map[chan pollEvent]struct{}),
+	}
+}
+
+// Publish records a poll result and sends it to all subscribers. A
+// subscriber whose buffer is full is disconnected rather than allowed
+// to hold up the poll loop; it can resume from the history.
+func (h *eventHub) Publish(snap snapshot) pollEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ev := pollEvent{Generation: snap.Generation, Time: snap.Time, Sensors: snap.Sensors}
+
+	prev := make(map[string]string)
+	if n := len(h.history); n > 0 {
+		for _, s := range h.history[n-1].Sensors {
+			prev[s.ID] = s.Value
+		}
+	}
+	for _, s := range snap.Sensors {
+		if v, ok := prev[s.ID]; !ok || v != s.Value {
+			ev.Changes = append(ev.Changes, sensorChange{ID: s.ID, Value: s.Value, Previous: v})
+		}
+	}
+
+	h.history = append(h.history, ev)
+	if over := len(h.history) - h.historySize; over > 0 {
+		h.history = h.history[over:]
+	}
+
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+	return ev
+}
+
+// Latest returns the most recent poll result.
+func (h *eventHub) Latest() (pollEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.history) == 0 {
+		return pollEvent{}, false
+	}
+	return h.history[len(h.history)-1], true
+}
+
+// History returns the retained poll results, oldest first.
+func (h *eventHub) History() []pollEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]pollEvent(nil), h.history...)
+}
+
+// Subscribe registers a subscriber and returns the retained events
+// after lastID along with the channel for new ones. If lastID is zero,
+// has already dropped out of the history or is from before a restart,
+// only the latest event is returned. ok is false when the subscriber
+// limit is reached.
+func (h *eventHub) Subscribe(lastID uint64) (backlog []pollEvent, ch chan pollEvent, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed || len(h.subs) >= h.maxSubs {
+		return nil, nil, false
+	}
+	ch = make(chan pollEvent, eventSubscriberBuffer)
+	h.subs[ch] = struct{}{}
+
+	if n := len(h.history); n > 0 {
+		if lastID == 0 || lastID < h.history[0].Generation-1 || lastID > h.history[n-1].Generation {
+			backlog = h.history[n-1:]
+		} else {
+			for i, ev := range h.history {
+				if ev.Generation > lastID {
+					backlog = h.history[i:]
+					break
+				}
+			}
+		}
+	}
+	return append([]pollEvent(nil), backlog...), ch, true
+}
+
+func (h *eventHub) Unsubscribe(ch chan pollEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Close disconnects all subscribers, so streaming handlers return
+// when the HTTP server shuts down.
+func (h *eventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// handleEvents streams poll results as Server-Sent Events: a "poll"
+// event with all sensors and the generation as ID, followed by a
+// "change" event per sensor whose value changed.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	if v := r.URL.Query().Get("last_event_id"); v != "" {
+		lastID, _ = strconv.ParseUint(v, 10, 64)
+	}
+
+	backlog, ch, ok := s.events.Subscribe(lastID)
+	if !ok {
+		writeJSONError(w, http.StatusServiceUnavailable, "too many subscribers")
+		return
+	}
+	defer s.events.Unsubscribe(ch)
+
+	// Streams outlive the server's WriteTimeout; deadlines are set per
+	// write instead.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("events: cannot clear write deadline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(f func() error) bool {
+		rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := f(); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	resumed := lastID != 0
+	for _, ev := range backlog {
+		if !write(func() error { return writePollEvent(w, ev, resumed) }) {
+			return
+		}
+	}
+	if len(backlog) == 0 && !write(func() error { _, err := fmt.Fprint(w, ": waiting for next poll\n\n"); return err }) {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.eventHeartbeat())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !write(func() error { return writePollEvent(w, ev, true) }) {
+				return
+			}
+		case <-heartbeat.C:
+			if !write(func() error { _, err := fmt.Fprint(w, ": heartbeat\n\n"); return err }) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *server) eventHeartbeat() time.Duration {
+	if s.heartbeat > 0 {
+		return s.heartbeat
+	}
+	return defaultEventHeartbeat
+}
+
+// writePollEvent writes ev as a poll event, followed by change events
+// if withChanges is set.
+func writePollEvent(w http.ResponseWriter, ev pollEvent, withChanges bool) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "id: %d\nevent: poll\ndata: %s\n\n", ev.Generation, data); err != nil {
+		return err
+	}
+	if !withChanges {
+		return nil
+	}
+	for _, c := range ev.Changes {
+		data, _ := json.Marshal(c)
+		if _, err := fmt.Fprintf(w, "event: change\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
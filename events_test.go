@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// publish publishes sensors as the poll after the latest one.
+func publish(h *eventHub, sensors []Sensor) pollEvent {
+	last, _ := h.Latest()
+	return h.Publish(snapshot{Sensors: sensors, Generation: last.Generation + 1, Time: time.Now()})
+}
+
+func TestEventHub_Changes(t *testing.T) {
+	h := newEventHub()
+	publish(h, []Sensor{{ID: "a", Value: "1"}, {ID: "b", Value: "2"}})
+	ev := publish(h, []Sensor{{ID: "a", Value: "1"}, {ID: "b", Value: "3"}, {ID: "c", Value: "4"}})
+
+	if ev.Generation != 2 {
+		t.Errorf("generation = %d, want 2", ev.Generation)
+	}
+	if len(ev.Changes) != 2 || ev.Changes[0] != (sensorChange{ID: "b", Value: "3", Previous: "2"}) ||
+		ev.Changes[1] != (sensorChange{ID: "c", Value: "4"}) {
+		t.Errorf("changes = %+v", ev.Changes)
+	}
+}
+
+func TestPoll_EventIDIsGeneration(t *testing.T) {
+	srv := &server{w1Path: "testdata/w1_bus_master1", events: newEventHub()}
+	srv.cache.Store(snapshot{Generation: 41})
+	captureLog(t)
+	srv.poll()
+
+	ev, _ := srv.events.Latest()
+	if snap := srv.snapshot(); ev.Generation != 42 || snap.Generation != 42 || !ev.Time.Equal(snap.Time) {
+		t.Errorf("event generation %d at %s, snapshot generation %d at %s", ev.Generation, ev.Time, snap.Generation, snap.Time)
+	}
+}
+
+func TestEventHub_Resume(t *testing.T) {
+	h := newEventHub()
+	h.historySize = 3
+	for i := 0; i < 5; i++ {
+		publish(h, reading("x"))
+	}
+
+	cases := []struct {
+		lastID uint64
+		want   []uint64
+	}{
+		{0, []uint64{5}},    // fresh client: latest only
+		{3, []uint64{4, 5}}, // resume
+		{2, []uint64{3, 4, 5}},
+		{1, []uint64{5}}, // too old
+		{5, nil},         // up to date
+		{9, []uint64{5}}, // from before a restart
+	}
+	for _, c := range cases {
+		backlog, ch, ok := h.Subscribe(c.lastID)
+		if !ok {
+			t.Fatal("subscribe failed")
+		}
+		var got []uint64
+		for _, ev := range backlog {
+			got = append(got, ev.Generation)
+		}
+		if len(got) != len(c.want) || (len(got) > 0 && (got[0] != c.want[0] || got[len(got)-1] != c.want[len(c.want)-1])) {
+			t.Errorf("Subscribe(%d) backlog = %v, want %v", c.lastID, got, c.want)
+		}
+		h.Unsubscribe(ch)
+	}
+}
+
+func TestEventHub_SlowSubscriberDropped(t *testing.T) {
+	h := newEventHub()
+	_, ch, _ := h.Subscribe(0)
+	for i := 0; i < eventSubscriberBuffer+1; i++ {
+		publish(h, reading("x"))
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != eventSubscriberBuffer {
+		t.Errorf("received %d events before disconnect, want %d", n, eventSubscriberBuffer)
+	}
+}
+
+// sseClient reads events from a stream, returning each event's lines.
+type sseClient struct {
+	resp *http.Response
+	sc   *bufio.Scanner
+}
+
+func dialSSE(t *testing.T, url, lastID string) *sseClient {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return &sseClient{resp: resp, sc: bufio.NewScanner(resp.Body)}
+}
+
+func (c *sseClient) next(t *testing.T) []string {
+	t.Helper()
+	var lines []string
+	for c.sc.Scan() {
+		if c.sc.Text() == "" {
+			return lines
+		}
+		lines = append(lines, c.sc.Text())
+	}
+	t.Fatalf("stream ended: %v", c.sc.Err())
+	return nil
+}
+
+func newEventsServer(t *testing.T, srv *server) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(srv.handleEvents))
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	t.Cleanup(func() {
+		srv.events.Close()
+		ts.Close()
+	})
+	return ts
+}
+
+func TestHandleEvents(t *testing.T) {
+	srv := &server{events: newEventHub(), heartbeat: 30 * time.Millisecond}
+	publish(srv.events, []Sensor{{ID: "hot_water_middle", Value: "48.750"}})
+	ts := newEventsServer(t, srv)
+
+	c := dialSSE(t, ts.URL, "")
+	if ct := c.resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content-type = %q", ct)
+	}
+	ev := c.next(t)
+	if len(ev) != 3 || ev[0] != "id: 1" || ev[1] != "event: poll" ||
+		!strings.Contains(ev[2], `"sensors":[{"id":"hot_water_middle","value":"48.750"}]`) {
+		t.Errorf("initial event = %q", ev)
+	}
+
+	// Heartbeats keep the stream open past the server's WriteTimeout.
+	time.Sleep(150 * time.Millisecond)
+	if hb := c.next(t); len(hb) != 1 || hb[0] != ": heartbeat" {
+		t.Errorf("heartbeat = %q", hb)
+	}
+
+	publish(srv.events, []Sensor{{ID: "hot_water_middle", Value: "49.000"}})
+	for ev = c.next(t); ev[0] == ": heartbeat"; ev = c.next(t) {
+	}
+	if ev[0] != "id: 2" {
+		t.Errorf("poll event = %q", ev)
+	}
+	if ch := c.next(t); len(ch) != 2 || ch[0] != "event: change" ||
+		ch[1] != `data: {"id":"hot_water_middle","value":"49.000","previous":"48.750"}` {
+		t.Errorf("change event = %q", ch)
+	}
+
+	// Resuming replays what was missed, including changes.
+	r := dialSSE(t, ts.URL, "1")
+	if ev := r.next(t); ev[0] != "id: 2" {
+		t.Errorf("resumed event = %q", ev)
+	}
+	if ch := r.next(t); ch[0] != "event: change" {
+		t.Errorf("resumed change = %q", ch)
+	}
+}
+
+func TestHandleEvents_SubscriberLimit(t *testing.T) {
+	srv := &server{events: newEventHub()}
+	srv.events.maxSubs = 1
+	ts := newEventsServer(t, srv)
+
+	c := dialSSE(t, ts.URL, "")
+	c.next(t) // "waiting for next poll"
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("status = %d (%s), want JSON 503", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
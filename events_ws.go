@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWSPingInterval = 30 * time.Second
+	defaultWSPongTimeout  = 10 * time.Second
+	wsClientQueue         = 16
+)
+
+// wsClientMessage is a message from a /ws client.
+type wsClientMessage struct {
+	Type string   `json:"type"` // "subscribe", "poll" or "ping"
+	IDs  []string `json:"ids,omitempty"`
+}
+
+// wsServerMessage is a message to a /ws client.
+type wsServerMessage struct {
+	Type       string     `json:"type"` // "readings", "subscribed", "poll_requested", "pong" or "error"
+	Generation uint64     `json:"generation,omitempty"`
+	Time       *time.Time `json:"time,omitempty"`
+	Sensors    []Sensor   `json:"sensors,omitempty"`
+	IDs        []string   `json:"ids,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// wsClient is one /ws connection. Replies to client messages go
+// through a bounded queue, and readings come from an eventHub
+// subscription, so a client that stops reading is disconnected
+// instead of blocking the poll loop.
+type wsClient struct {
+	ws   *wsConn
+	out  chan wsServerMessage
+	done chan struct{}
+	once sync.Once
+
+	mu  sync.Mutex
+	ids []string // subscribed sensor IDs; empty means all
+}
+
+// handleWS serves live readings over WebSocket. Clients receive the
+// latest readings on connect and after every poll, and may send
+//
+//	{"type":"subscribe","ids":["hot_water_middle"]}  (empty ids: all sensors)
+//	{"type":"poll"}                                   (request an immediate poll)
+//	{"type":"ping"}
+func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
+	backlog, events, ok := s.events.Subscribe(0)
+	if !ok {
+		writeJSONError(w, http.StatusServiceUnavailable, "too many subscribers")
+		return
+	}
+	defer s.events.Unsubscribe(events)
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	pingInterval, _ := s.wsKeepalive()
+	c := &wsClient{
+		ws:   ws,
+		out:  make(chan wsServerMessage, wsClientQueue),
+		done: make(chan struct{}),
+	}
+	// A client that answers neither pings nor sends anything is dropped
+	// once the read deadline passes.
+	ws.onPong = func() { ws.conn.SetReadDeadline(time.Now().Add(s.wsReadTimeout())) }
+	ws.onPong()
+	go c.readLoop(s)
+
+	for _, ev := range backlog {
+		c.writeReadings(ev)
+	}
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				ws.WriteMessage(wsClose, closePayload(1001, "too slow or shutting down"))
+				return
+			}
+			err = c.writeReadings(ev)
+		case msg := <-c.out:
+			err = c.write(msg)
+		case <-ping.C:
+			err = ws.WriteMessage(wsPing, nil)
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *server) wsKeepalive() (time.Duration, time.Duration) {
+	if s.wsPingInterval > 0 {
+		return s.wsPingInterval, s.wsPongTimeout
+	}
+	return defaultWSPingInterval, defaultWSPongTimeout
+}
+
+func (c *wsClient) readLoop(s *server) {
+	defer c.close()
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.ws.conn.SetReadDeadline(time.Now().Add(s.wsReadTimeout()))
+
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.send(wsServerMessage{Type: "error", Error: "invalid JSON: " + err.Error()})
+			continue
+		}
+		switch msg.Type {
+		case "subscribe":
+			c.mu.Lock()
+			c.ids = msg.IDs
+			c.mu.Unlock()
+			c.send(wsServerMessage{Type: "subscribed", IDs: msg.IDs})
+			if ev, ok := s.events.Latest(); ok {
+				c.send(c.readings(ev))
+			}
+		case "poll":
+			s.requestPoll()
+			c.send(wsServerMessage{Type: "poll_requested"})
+		case "ping":
+			c.send(wsServerMessage{Type: "pong"})
+		default:
+			c.send(wsServerMessage{Type: "error", Error: "unknown message type " + msg.Type})
+		}
+	}
+}
+
+func (s *server) wsReadTimeout() time.Duration {
+	interval, timeout := s.wsKeepalive()
+	return interval + timeout
+}
+
+// send queues a reply, disconnecting the client if its queue is full.
+func (c *wsClient) send(msg wsServerMessage) {
+	select {
+	case c.out <- msg:
+	default:
+		log.Println("ws: client queue full, disconnecting")
+		c.close()
+	}
+}
+
+func (c *wsClient) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// readings filters a poll result down to the subscribed sensors.
+func (c *wsClient) readings(ev pollEvent) wsServerMessage {
+	c.mu.Lock()
+	ids := c.ids
+	c.mu.Unlock()
+
+	sensors := ev.Sensors
+	if len(ids) > 0 {
+		sensors = nil
+		for _, s := range ev.Sensors {
+			if slices.Contains(ids, s.ID) {
+				sensors = append(sensors, s)
+			}
+		}
+	}
+	t := ev.Time
+	return wsServerMessage{Type: "readings", Generation: ev.Generation, Time: &t, Sensors: sensors}
+}
+
+func (c *wsClient) writeReadings(ev pollEvent) error {
+	msg := c.readings(ev)
+	if len(msg.Sensors) == 0 {
+		return nil
+	}
+	return c.write(msg)
+}
+
+func (c *wsClient) write(msg wsServerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.ws.WriteMessage(wsText, data)
+}
+
+// closePayload builds a close frame body: a status code followed by a
+// UTF-8 reason.
+func closePayload(code uint16, reason string) []byte {
+	return append([]byte{byte(code >> 8), byte(code)}, reason...)
+}
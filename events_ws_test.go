@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dialTestWS(t *testing.T, srv *server) *wsConn {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleWS))
+	t.Cleanup(func() {
+		srv.events.Close()
+		ts.Close()
+	})
+	ws, err := dialWebSocket("ws"+strings.TrimPrefix(ts.URL, "http"), time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func readWSMessage(t *testing.T, ws *wsConn) wsServerMessage {
+	t.Helper()
+	ws.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var msg wsServerMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	return msg
+}
+
+func TestHandleWS(t *testing.T) {
+	srv := &server{events: newEventHub(), trigger: make(chan struct{}, 1)}
+	publish(srv.events, []Sensor{{ID: "hot_water_middle", Value: "48.750"}, {ID: "heating_flow", Value: "55.000"}})
+	ws := dialTestWS(t, srv)
+
+	if msg := readWSMessage(t, ws); msg.Type != "readings" || msg.Generation != 1 || len(msg.Sensors) != 2 {
+		t.Errorf("initial = %+v", msg)
+	}
+
+	ws.WriteMessage(wsText, []byte(`{"type":"subscribe","ids":["heating_flow"]}`))
+	if msg := readWSMessage(t, ws); msg.Type != "subscribed" || len(msg.IDs) != 1 {
+		t.Errorf("subscribe reply = %+v", msg)
+	}
+	if msg := readWSMessage(t, ws); msg.Type != "readings" || len(msg.Sensors) != 1 || msg.Sensors[0].ID != "heating_flow" {
+		t.Errorf("filtered latest = %+v", msg)
+	}
+
+	publish(srv.events, []Sensor{{ID: "hot_water_middle", Value: "49.000"}, {ID: "heating_flow", Value: "56.000"}})
+	if msg := readWSMessage(t, ws); msg.Generation != 2 || len(msg.Sensors) != 1 || msg.Sensors[0].Value != "56.000" {
+		t.Errorf("poll = %+v", msg)
+	}
+
+	ws.WriteMessage(wsText, []byte(`{"type":"poll"}`))
+	if msg := readWSMessage(t, ws); msg.Type != "poll_requested" {
+		t.Errorf("poll reply = %+v", msg)
+	}
+	select {
+	case <-srv.trigger:
+	default:
+		t.Error("poll request did not reach the poll loop")
+	}
+
+	ws.WriteMessage(wsText, []byte(`{"type":"ping"}`))
+	if msg := readWSMessage(t, ws); msg.Type != "pong" {
+		t.Errorf("ping reply = %+v", msg)
+	}
+	ws.WriteMessage(wsText, []byte(`{"type":"bogus"}`))
+	if msg := readWSMessage(t, ws); msg.Type != "error" {
+		t.Errorf("unknown type reply = %+v", msg)
+	}
+}
+
+func TestHandleWS_Keepalive(t *testing.T) {
+	srv := &server{events: newEventHub(), wsPingInterval: 20 * time.Millisecond, wsPongTimeout: 50 * time.Millisecond}
+	ws := dialTestWS(t, srv)
+
+	// ReadMessage answers the server's pings, which keeps the
+	// connection open well past the read deadline.
+	publish(srv.events, reading("x"))
+	readWSMessage(t, ws)
+	time.AfterFunc(200*time.Millisecond, func() { publish(srv.events, reading("x")) })
+	if msg := readWSMessage(t, ws); msg.Generation != 2 {
+		t.Errorf("after idle period = %+v", msg)
+	}
+}
+
+func TestHandleWS_SlowClientDropped(t *testing.T) {
+	srv := &server{events: newEventHub()}
+	ws := dialTestWS(t, srv)
+
+	// The client never reads; publishing must not block, and the server
+	// eventually closes the connection.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		big := make([]Sensor, 2000)
+		for i := range big {
+			big[i] = Sensor{ID: "hot_water_middle", Value: "48.750"}
+		}
+		for i := 0; i < 200; i++ {
+			publish(srv.events, big)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a slow websocket client")
+	}
+
+	ws.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+func TestHandleWS_NotUpgrade(t *testing.T) {
+	srv := &server{events: newEventHub()}
+	rec := httptest.NewRecorder()
+	srv.handleWS(rec, httptest.NewRequest("GET", "/ws", nil))
+	if rec.Code != http.StatusUpgradeRequired {
+		t.Errorf("status = %d, want 426", rec.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Response formats for /sensors.
+const (
+	formatJSON        = "json"
+	formatText        = "text"        // "id value" lines
+	formatCSV         = "csv"         // header row, then one row per sensor
+	formatPrometheus  = "prometheus"  // text exposition format
+	formatOpenMetrics = "openmetrics" // OpenMetrics text format
+	formatRaw         = "raw"         // the bare value; /sensors/{id} only
+)
+
+// formatMediaTypes maps Accept media types to formats. text/plain means
+// the bare value for a single sensor.
+var formatMediaTypes = map[string]string{
+	"application/json":             formatJSON,
+	"text/plain":                   formatText,
+	"text/csv":                     formatCSV,
+	"application/openmetrics-text": formatOpenMetrics,
+}
+
+// negotiateFormat picks the response format from ?format= or, failing
+// that, the Accept header (honouring q-values). JSON is the default.
+func negotiateFormat(r *http.Request, single bool) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		switch f {
+		case formatJSON, formatText, formatCSV, formatPrometheus, formatOpenMetrics:
+			return f, nil
+		case formatRaw:
+			if single {
+				return f, nil
+			}
+		}
+		return "", fmt.Errorf("unsupported format %q", f)
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, nil
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var f string
+		switch {
+		case mt == "text/plain" && params["version"] == "0.0.4":
+			f = formatPrometheus
+		case mt == "text/plain" && single:
+			f = formatRaw
+		case mt == "*/*" || mt == "application/*":
+			f = formatJSON
+		case mt == "text/*":
+			f = formatText
+		default:
+			f = formatMediaTypes[mt]
+		}
+		if f != "" && q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("none of the accepted media types is supported (use application/json, text/plain, text/csv or application/openmetrics-text)")
+	}
+	return best, nil
+}
+
+// writeSensors writes sensors in one of the non-JSON formats, with the
+// selected fields (id and value by default) for text and CSV.
+func writeSensors(w http.ResponseWriter, format string, q sensorQuery, sensors []Sensor) {
+	fields := q.fields
+	if fields == nil {
+		fields = []string{"id", "value"}
+	}
+
+	switch format {
+	case formatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, s := range sensors {
+			vals := make([]string, len(fields))
+			for i, f := range fields {
+				vals[i] = sensorField(s, f)
+			}
+			fmt.Fprintln(w, strings.Join(vals, " "))
+		}
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(fields)
+		for _, s := range sensors {
+			row := make([]string, len(fields))
+			for i, f := range fields {
+				row[i] = sensorField(s, f)
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+	case formatPrometheus:
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writePrometheus(w, sensors)
+	case formatOpenMetrics:
+		// The gauges are the same; OpenMetrics requires the end marker.
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		writePrometheus(w, sensors)
+		fmt.Fprintln(w, "# EOF")
+	case formatRaw:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, s := range sensors {
+			fmt.Fprintln(w, s.Value)
+		}
+	}
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writePrometheus writes the readings as gauges, using the metric
+// names of the remote-write output. Values that are not numbers are
+// skipped.
+func writePrometheus(w http.ResponseWriter, sensors []Sensor) {
+	byMetric := make(map[string][]Sensor)
+	var names []string
+	for _, s := range sensors {
+		if _, err := strconv.ParseFloat(s.Value, 64); err != nil {
+			continue
+		}
+		name := metricName(s)
+		if _, ok := byMetric[name]; !ok {
+			names = append(names, name)
+		}
+		byMetric[name] = append(byMetric[name], s)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		for _, s := range byMetric[name] {
+			fmt.Fprintf(w, "%s{sensor=\"%s\"} %s\n", name, promLabelEscaper.Replace(s.ID), s.Value)
+		}
+	}
+}
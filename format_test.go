@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		target, accept string
+		single         bool
+		want           string
+	}{
+		{"/sensors", "", false, formatJSON},
+		{"/sensors", "*/*", false, formatJSON},
+		{"/sensors", "text/plain", false, formatText},
+		{"/sensors/x", "text/plain", true, formatRaw},
+		{"/sensors", "text/csv", false, formatCSV},
+		{"/sensors", "text/plain;version=0.0.4;q=0.9, */*;q=0.1", false, formatPrometheus},
+		{"/sensors", "application/openmetrics-text;version=1.0.0, text/plain;version=0.0.4;q=0.5", false, formatOpenMetrics},
+		{"/sensors", "application/json;q=0.5, text/csv", false, formatCSV},
+		{"/sensors?format=text", "application/json", false, formatText},
+		{"/sensors/x?format=raw", "", true, formatRaw},
+		{"/sensors/x?format=text", "", true, formatText},
+		{"/sensors?format=raw", "", false, ""},
+		{"/sensors?format=xml", "", false, ""},
+		{"/sensors", "application/xml", false, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.target, nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		got, err := negotiateFormat(r, c.single)
+		if got != c.want || (err != nil) != (c.want == "") {
+			t.Errorf("%s Accept %q: got %q, %v, want %q", c.target, c.accept, got, err, c.want)
+		}
+	}
+}
+
+func TestHandleSensors_Formats(t *testing.T) {
+	_, mux := newAPIServer(apiSensors)
+
+	cases := []struct {
+		target, accept string
+		status         int
+		contentType    string
+		body           string
+	}{
+		{"/sensors?id=hot_water_middle,heating_flow", "text/plain", 200, "text/plain; charset=utf-8",
+			"hot_water_middle 48.750\nheating_flow 55.000\n"},
+		{"/sensors?kind=humidity&fields=id,value,unit", "text/csv", 200, "text/csv; charset=utf-8",
+			"id,value,unit\nutility_room_humidity,49.3,%\n"},
+		{"/sensors?format=prometheus&location=utility_room", "", 200, "text/plain; version=0.0.4; charset=utf-8",
+			"# TYPE tempsensor_humidity_percent gauge\n" +
+				"tempsensor_humidity_percent{sensor=\"utility_room_humidity\"} 49.3\n" +
+				"# TYPE tempsensor_temperature_celsius gauge\n" +
+				"tempsensor_temperature_celsius{sensor=\"utility_room_temperature\"} 21.3\n"},
+		{"/sensors?location=utility_room&kind=humidity", "application/openmetrics-text", 200, "application/openmetrics-text; version=1.0.0; charset=utf-8",
+			"# TYPE tempsensor_humidity_percent gauge\n" +
+				"tempsensor_humidity_percent{sensor=\"utility_room_humidity\"} 49.3\n" +
+				"# EOF\n"},
+		{"/sensors/hot_water_middle", "text/plain", 200, "text/plain; charset=utf-8", "48.750\n"},
+		{"/sensors/hot_water_middle?format=raw", "", 200, "text/plain; charset=utf-8", "48.750\n"},
+		{"/sensors?format=raw", "", 400, "application/json", "{\"error\":\"unsupported format \\\"raw\\\"\",\"status\":400}\n"},
+		{"/sensors/garage", "text/plain", 404, "application/json", "{\"error\":\"sensor \\\"garage\\\" not found\",\"status\":404}\n"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.target, nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != c.status || rec.Header().Get("Content-Type") != c.contentType || rec.Body.String() != c.body {
+			t.Errorf("%s (%s): %d %q\n%s", c.target, c.accept, rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/sensors", nil)
+	req.Header.Set("Accept", "image/png")
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("unsupported Accept: status = %d, want 406", rec.Code)
+	}
+}
+
+func TestWritePrometheus_Unmapped(t *testing.T) {
+	// An unmapped probe and the DHT22 keep their metric names whether
+	// or not an entity mapping exists.
+	rec := httptest.NewRecorder()
+	writePrometheus(rec, []Sensor{
+		{ID: "0", Value: "19.812", Address: "28-0417b1a3f0aa", Driver: "w1"},
+		{ID: "garage_humidity", Value: "61.0", Driver: "iio"},
+	})
+	want := "# TYPE tempsensor_humidity_percent gauge\n" +
+		"tempsensor_humidity_percent{sensor=\"garage_humidity\"} 61.0\n" +
+		"# TYPE tempsensor_temperature_celsius gauge\n" +
+		"tempsensor_temperature_celsius{sensor=\"0\"} 19.812\n"
+	if rec.Body.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", rec.Body, want)
+	}
+}
@@ -6,69 +6,241 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"math"
 	"net/http"
+	"os"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
 )
 
+const (
+	defaultHAHeartbeat        = 5 * time.Minute
+	defaultHAUnavailableAfter = 5 * time.Minute
+)
+
+// haExtraAttributes are the per-reading attributes an entity can opt
+// into with Extras.
+var haExtraAttributes = []string{"address", "model", "resolution", "last_read", "read_errors"}
+
+// defaultHAExtras only includes attributes that rarely change, as HA
+// records a new attribute set whenever one does.
+var defaultHAExtras = []string{"address", "model", "resolution"}
+
+// sensorMeta describes the HA entity a sensor is pushed to. A state is
+// only pushed when it differs from the last pushed state by more than
+// Deadband or when Heartbeat has passed since. A zero Heartbeat
+// disables the heartbeat; with a zero Deadband as well, every poll is
+// pushed.
 type sensorMeta struct {
-	EntityID     string
-	FriendlyName string
-	Unit         string
-	DeviceClass  string
+	EntityID     string        `json:"entity_id"`
+	FriendlyName string        `json:"friendly_name"`
+	Unit         string        `json:"unit"`
+	DeviceClass  string        `json:"device_class"`
+	Location     string        `json:"location"`
+	Deadband     float64       `json:"deadband"`
+	Heartbeat    time.Duration `json:"heartbeat"`
+
+	Icon       string            `json:"icon,omitempty"`
+	Area       string            `json:"area,omitempty"`
+	Precision  *int              `json:"precision,omitempty"` // suggested_display_precision
+	Extras     []string          `json:"extra_attributes,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"` // sent as-is
+}
+
+// UnmarshalJSON accepts heartbeat as a duration string such as "5m".
+func (m *sensorMeta) UnmarshalJSON(data []byte) error {
+	type plain sensorMeta
+	aux := struct {
+		*plain
+		Heartbeat string `json:"heartbeat"`
+	}{plain: (*plain)(m)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Heartbeat != "" {
+		d, err := time.ParseDuration(aux.Heartbeat)
+		if err != nil {
+			return fmt.Errorf("heartbeat: %w", err)
+		}
+		m.Heartbeat = d
+	}
+	for _, name := range m.Extras {
+		if !slices.Contains(haExtraAttributes, name) {
+			return fmt.Errorf("unknown extra attribute %q", name)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes heartbeat as a duration string, matching the
+// HA_ENTITIES file format.
+func (m sensorMeta) MarshalJSON() ([]byte, error) {
+	type plain sensorMeta
+	return json.Marshal(struct {
+		plain
+		Heartbeat string `json:"heartbeat"`
+	}{plain(m), m.Heartbeat.String()})
 }
 
+func intPtr(v int) *int { return &v }
+
 var sensorMetaMap = map[string]sensorMeta{
 	"hot_water_middle": {
 		EntityID:     "sensor.warmwasser_mitte",
 		FriendlyName: "Warmwasser Mitte",
 		Unit:         "°C",
 		DeviceClass:  "temperature",
+		Deadband:     0.2,
+		Heartbeat:    defaultHAHeartbeat,
+		Location:     "hot_water_tank",
+		Icon:         "mdi:water-boiler",
+		Precision:    intPtr(1),
+		Extras:       defaultHAExtras,
 	},
 	"heating_supply": {
 		EntityID:     "sensor.heizung_vorlauf",
 		FriendlyName: "Heizung Vorlauf",
 		Unit:         "°C",
 		DeviceClass:  "temperature",
+		Deadband:     0.2,
+		Heartbeat:    defaultHAHeartbeat,
+		Location:     "heating",
+		Icon:         "mdi:radiator",
+		Precision:    intPtr(1),
+		Extras:       defaultHAExtras,
 	},
 	"hot_water_bottom": {
 		EntityID:     "sensor.warmwasser_unten",
 		FriendlyName: "Warmwasser Unten",
 		Unit:         "°C",
 		DeviceClass:  "temperature",
+		Deadband:     0.2,
+		Heartbeat:    defaultHAHeartbeat,
+		Location:     "hot_water_tank",
+		Icon:         "mdi:water-boiler",
+		Precision:    intPtr(1),
+		Extras:       defaultHAExtras,
 	},
 	"heating_return": {
 		EntityID:     "sensor.heizung_rucklauf",
 		FriendlyName: "Heizung Rücklauf",
 		Unit:         "°C",
 		DeviceClass:  "temperature",
+		Deadband:     0.2,
+		Heartbeat:    defaultHAHeartbeat,
+		Location:     "heating",
+		Icon:         "mdi:radiator",
+		Precision:    intPtr(1),
+		Extras:       defaultHAExtras,
 	},
 	"utility_room_temperature": {
 		EntityID:     "sensor.technikraum_temperatur",
 		FriendlyName: "Technikraum Temperatur",
 		Unit:         "°C",
 		DeviceClass:  "temperature",
+		Deadband:     0.2,
+		Heartbeat:    defaultHAHeartbeat,
+		Location:     "utility_room",
+		Icon:         "mdi:thermometer",
+		Precision:    intPtr(1),
+		Extras:       defaultHAExtras,
 	},
 	"utility_room_humidity": {
 		EntityID:     "sensor.technikraum_luftfeuchtigkeit",
 		FriendlyName: "Technikraum Luftfeuchtigkeit",
 		Unit:         "%",
 		DeviceClass:  "humidity",
+		Deadband:     1,
+		Heartbeat:    defaultHAHeartbeat,
+		Location:     "utility_room",
+		Icon:         "mdi:water-percent",
+		Precision:    intPtr(1),
+		Extras:       defaultHAExtras,
 	},
 }
 
+// LoadEntityConfig merges a JSON object of sensor ID → entity
+// settings from path into sensorMetaMap. Fields omitted for a known
+// sensor keep their built-in values.
+func LoadEntityConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for id, entry := range raw {
+		meta, ok := sensorMetaMap[id]
+		if !ok {
+			meta.Heartbeat = defaultHAHeartbeat
+		}
+		// Unmarshal writes into existing slices, maps and pointers, which
+		// are shared with the built-in entry.
+		meta.Extras = slices.Clone(meta.Extras)
+		meta.Attributes = maps.Clone(meta.Attributes)
+		if meta.Precision != nil {
+			meta.Precision = intPtr(*meta.Precision)
+		}
+		if err := json.Unmarshal(entry, &meta); err != nil {
+			return fmt.Errorf("parse %s: %s: %w", path, id, err)
+		}
+		if meta.EntityID == "" {
+			return fmt.Errorf("%s: %s: missing entity_id", path, id)
+		}
+		sensorMetaMap[id] = meta
+	}
+	return nil
+}
+
 type haPayload struct {
-	State      string            `json:"state"`
-	Attributes map[string]string `json:"attributes"`
+	State      string         `json:"state"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// haSentState is the last state accepted for an entity, sent or
+// queued, which the push policy compares new readings against.
+type haSentState struct {
+	value float64
+	at    time.Time
 }
 
 type haPusher struct {
-	url      string
-	token    string
-	client   *http.Client
-	mu       sync.Mutex
-	failures int
+	url         string
+	token       string
+	client      *http.Client
+	ws          *haWebSocket // if set, states are sent over the WebSocket API instead of REST
+	queue       *haQueue     // states that failed with a retryable error, replayed first
+	breaker     *circuitBreaker
+	concurrency int // entities sent at once
+
+	// autoEntities generates entities for sensors missing from
+	// sensorMetaMap instead of skipping them.
+	autoEntities bool
+	genMu        sync.Mutex
+	generated    map[string]generatedEntity // by suggested sensor ID
+
+	// unavailableAfter is how long a sensor may be missing before its
+	// entity is set to unavailable; zero disables this.
+	unavailableAfter time.Duration
+
+	failures    int
+	lastSent    map[string]haSentState
+	lastSeen    map[string]haSeen // last reading per entity ID
+	unavailable map[string]bool   // entity IDs currently marked unavailable
+}
+
+// haSeen is when an entity last had a reading. key is the sensor ID it
+// is configured under in sensorMetaMap or, for generated entities, in
+// generated; unmapped 1-Wire sensor IDs move between probes, so they
+// cannot identify the entity.
+type haSeen struct {
+	key string
+	at  time.Time
 }
 
 func NewHAPusher(url, token string) *haPusher {
@@ -78,32 +250,110 @@ func NewHAPusher(url, token string) *haPusher {
 		client: &http.Client{
 			Timeout: 5 * time.Second,
 		},
+		queue:            newHAQueue(),
+		breaker:          newCircuitBreaker("ha"),
+		concurrency:      len(sensorMetaMap),
+		unavailableAfter: defaultHAUnavailableAfter,
+		lastSent:         make(map[string]haSentState),
+		lastSeen:         make(map[string]haSeen),
+		generated:        make(map[string]generatedEntity),
+		unavailable:      make(map[string]bool),
 	}
 }
 
-func (p *haPusher) Push(sensors []Sensor) {
-	if !p.mu.TryLock() {
-		log.Println("ha: push still in progress, skipping")
-		return
-	}
-	defer p.mu.Unlock()
+func (p *haPusher) Name() string { return "homeassistant" }
+
+// Backlog reports the number of states waiting to be replayed.
+func (p *haPusher) Backlog() int { return p.queue.Len() }
 
-	pushed := 0
+func (p *haPusher) BreakerStatus() breakerStatus { return p.breaker.Status() }
+
+// Push replays any queued states and then sends the new ones, up to
+// concurrency entities at a time. Readings the entity's push policy
+// considers unchanged are skipped. Entities whose sensor has been
+// missing for unavailableAfter are set to unavailable. While a
+// backlog remains, or while the circuit breaker is open, new states
+// are queued so each entity's history reaches HA in order.
+func (p *haPusher) Push(sensors []Sensor) error {
+	now := time.Now()
+	var states []haQueuedState
+	var lastErr error
+	unchanged := 0
 	for _, s := range sensors {
-		meta, ok := sensorMetaMap[s.ID]
+		key := s.ID
+		meta, ok := sensorMetaMap[key]
+		if !ok && p.autoEntities {
+			key, meta, ok = p.generatedEntity(s)
+		}
 		if !ok {
 			continue
 		}
-		if err := p.pushSensor(s, meta); err != nil {
-			p.failures++
-			if p.failures == 1 || p.failures%10 == 0 {
-				log.Printf("ha: push %s failed (%d consecutive): %v",
-					meta.EntityID, p.failures, err)
+		val, err := strconv.ParseFloat(s.Value, 64)
+		if err != nil {
+			err = fmt.Errorf("parse value %q: %w", s.Value, err)
+			lastErr = err
+			p.fail(meta.EntityID, err)
+			continue
+		}
+		p.lastSeen[meta.EntityID] = haSeen{key: key, at: now}
+		if p.unavailable[meta.EntityID] {
+			delete(p.unavailable, meta.EntityID)
+			log.Printf("ha: %s is back, restoring %s", s.ID, meta.EntityID)
+		} else if !p.shouldPush(meta, val, now) {
+			unchanged++
+			continue
+		}
+		states = append(states, haQueuedState{
+			EntityID: meta.EntityID,
+			Payload:  statePayload(s, val, meta),
+			value:    val,
+		})
+	}
+
+	states = append(states, p.missingStates(now)...)
+
+	if !p.breaker.Allow() {
+		for _, st := range states {
+			p.enqueue(st, now)
+		}
+		p.saveQueue()
+		return fmt.Errorf("circuit open, %d states queued", p.queue.Len())
+	}
+
+	pushed, err := p.replay()
+	unreachable := err != nil
+	if err != nil {
+		lastErr = err
+	}
+
+	if p.queue.Len() > 0 {
+		for _, st := range states {
+			p.enqueue(st, now)
+		}
+		states = nil
+	}
+
+	for i, err := range p.sendConcurrently(states) {
+		st := states[i]
+		if err != nil {
+			lastErr = err
+			p.fail(st.EntityID, err)
+			if isRetryable(err) {
+				unreachable = true
+				p.enqueue(st, now)
 			}
 			continue
 		}
+		p.lastSent[st.EntityID] = haSentState{value: st.value, at: now}
 		pushed++
 	}
+	p.saveQueue()
+
+	if unreachable {
+		p.breaker.Failure()
+	} else {
+		p.breaker.Success()
+	}
 
 	if pushed > 0 && p.failures > 0 {
 		log.Printf("ha: recovered after %d failures", p.failures)
@@ -111,31 +361,209 @@ func (p *haPusher) Push(sensors []Sensor) {
 	if pushed > 0 {
 		p.failures = 0
 	}
-	log.Printf("ha: pushed %d sensors", pushed)
+	switch n := p.queue.Len(); {
+	case n > 0:
+		log.Printf("ha: pushed %d sensors, %d unchanged, %d queued", pushed, unchanged, n)
+	case unchanged > 0:
+		log.Printf("ha: pushed %d sensors, %d unchanged", pushed, unchanged)
+	default:
+		log.Printf("ha: pushed %d sensors", pushed)
+	}
+	return lastErr
 }
 
-func (p *haPusher) pushSensor(s Sensor, meta sensorMeta) error {
-	val, err := strconv.ParseFloat(s.Value, 64)
-	if err != nil {
-		return fmt.Errorf("parse value %q: %w", s.Value, err)
+// replay sends queued states oldest first until the queue is empty or
+// a retryable error shows HA is still unreachable. States rejected
+// for other reasons are dropped.
+func (p *haPusher) replay() (int, error) {
+	if dropped := p.queue.CoalesceIfLarge(); dropped > 0 {
+		log.Printf("ha: backlog too large, coalesced to newest state per entity (%d dropped)", dropped)
 	}
 
-	payload := haPayload{
-		State: fmt.Sprintf("%.1f", val),
-		Attributes: map[string]string{
-			"friendly_name":       meta.FriendlyName,
-			"unit_of_measurement": meta.Unit,
-			"device_class":        meta.DeviceClass,
-			"state_class":         "measurement",
-		},
+	sent := 0
+	for {
+		item, ok := p.queue.Front()
+		if !ok {
+			break
+		}
+		if err := p.sendState(item.EntityID, item.Payload); err != nil {
+			p.fail(item.EntityID, err)
+			if isRetryable(err) {
+				return sent, err
+			}
+			log.Printf("ha: dropping queued state for %s: %v", item.EntityID, err)
+		} else {
+			sent++
+		}
+		p.queue.Pop()
+	}
+	if sent > 0 {
+		log.Printf("ha: replayed %d queued states", sent)
+	}
+	return sent, nil
+}
+
+// missingStates returns an unavailable state for each entity, mapped
+// or generated, that has had a reading before but not for
+// unavailableAfter.
+func (p *haPusher) missingStates(now time.Time) []haQueuedState {
+	if p.unavailableAfter <= 0 {
+		return nil
+	}
+	var states []haQueuedState
+	for entityID, seen := range p.lastSeen {
+		if p.unavailable[entityID] || now.Sub(seen.at) < p.unavailableAfter {
+			continue
+		}
+		meta, ok := sensorMetaMap[seen.key]
+		if !ok {
+			p.genMu.Lock()
+			gen, found := p.generated[seen.key]
+			p.genMu.Unlock()
+			meta, ok = gen.Meta, found
+		}
+		if !ok {
+			continue
+		}
+		p.unavailable[entityID] = true
+		log.Printf("ha: %s missing since %s, marking %s unavailable",
+			seen.key, seen.at.Format(time.RFC3339), entityID)
+		states = append(states, haQueuedState{
+			EntityID: entityID,
+			Payload:  unavailablePayload(seen.key, meta, seen.at),
+		})
+	}
+	return states
+}
+
+// shouldPush applies the entity's deadband and heartbeat.
+func (p *haPusher) shouldPush(meta sensorMeta, val float64, now time.Time) bool {
+	if meta.Heartbeat <= 0 && meta.Deadband <= 0 {
+		return true
+	}
+	last, ok := p.lastSent[meta.EntityID]
+	if !ok || (meta.Heartbeat > 0 && now.Sub(last.at) >= meta.Heartbeat) {
+		return true
+	}
+	return math.Abs(val-last.value) > meta.Deadband
+}
+
+// enqueue queues a state for replay. It counts as sent for the push
+// policy, so an unchanged reading is not queued again every poll.
+func (p *haPusher) enqueue(st haQueuedState, now time.Time) {
+	p.queue.Add(st.EntityID, st.Payload)
+	p.lastSent[st.EntityID] = haSentState{value: st.value, at: now}
+}
+
+// sendConcurrently sends states with at most p.concurrency requests
+// in flight and returns each state's error.
+func (p *haPusher) sendConcurrently(states []haQueuedState) []error {
+	errs := make([]error, len(states))
+	sem := make(chan struct{}, max(p.concurrency, 1))
+	var wg sync.WaitGroup
+	for i, st := range states {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			errs[i] = p.sendState(st.EntityID, st.Payload)
+			<-sem
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func (p *haPusher) saveQueue() {
+	if err := p.queue.Save(); err != nil {
+		log.Printf("ha: saving queue: %v", err)
+	}
+}
+
+func (p *haPusher) fail(entityID string, err error) {
+	p.failures++
+	if p.failures == 1 || p.failures%10 == 0 {
+		log.Printf("ha: push %s failed (%d consecutive): %v",
+			entityID, p.failures, err)
 	}
+}
 
+func statePayload(s Sensor, val float64, meta sensorMeta) haPayload {
+	attrs := entityAttributes(meta)
+	attrs["state_class"] = "measurement"
+	for _, name := range meta.Extras {
+		switch name {
+		case "address":
+			attrs["address"] = s.Address
+		case "model":
+			attrs["model"] = s.Model
+		case "resolution":
+			if s.Resolution > 0 {
+				attrs["resolution"] = s.Resolution
+			}
+		case "last_read":
+			attrs["last_read"] = s.ReadAt.Format(time.RFC3339)
+		case "read_errors":
+			e, _ := readErrors.Get(s.ID)
+			attrs["read_errors"] = e.Count
+		}
+	}
+	return haPayload{
+		State:      fmt.Sprintf("%.1f", val),
+		Attributes: attrs,
+	}
+}
+
+// entityAttributes returns the attributes configured for an entity,
+// independent of the current reading.
+func entityAttributes(meta sensorMeta) map[string]any {
+	attrs := map[string]any{
+		"friendly_name":       meta.FriendlyName,
+		"unit_of_measurement": meta.Unit,
+		"device_class":        meta.DeviceClass,
+	}
+	if meta.Icon != "" {
+		attrs["icon"] = meta.Icon
+	}
+	if meta.Area != "" {
+		attrs["area"] = meta.Area
+	}
+	if meta.Precision != nil {
+		attrs["suggested_display_precision"] = *meta.Precision
+	}
+	for k, v := range meta.Attributes {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// unavailablePayload reports a missing sensor with its last read
+// error, if it failed after it was last seen.
+func unavailablePayload(id string, meta sensorMeta, lastSeen time.Time) haPayload {
+	lastErr := "no reading"
+	if e, ok := readErrors.Get(id); ok && e.At.After(lastSeen) {
+		lastErr = e.Err
+	}
+	attrs := entityAttributes(meta)
+	attrs["last_error"] = lastErr
+	attrs["last_seen"] = lastSeen.Format(time.RFC3339)
+	return haPayload{State: "unavailable", Attributes: attrs}
+}
+
+func (p *haPusher) sendState(entityID string, payload haPayload) error {
+	if p.ws != nil {
+		return p.ws.SetState(entityID, payload)
+	}
+	return p.postState(entityID, payload)
+}
+
+func (p *haPusher) postState(entityID string, payload haPayload) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
 
-	url := p.url + "/api/states/" + meta.EntityID
+	url := p.url + "/api/states/" + entityID
 	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("new request: %w", err)
@@ -145,7 +573,7 @@ func (p *haPusher) pushSensor(s Sensor, meta sensorMeta) error {
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("do request: %w", err)
+		return retryable(fmt.Errorf("do request: %w", err))
 	}
 	defer func() {
 		io.Copy(io.Discard, resp.Body)
@@ -153,8 +581,19 @@ func (p *haPusher) pushSensor(s Sensor, meta sensorMeta) error {
 	}()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("unexpected status %d for %s", resp.StatusCode, meta.EntityID)
+		err := fmt.Errorf("unexpected status %d for %s", resp.StatusCode, entityID)
+		if retryableStatus(resp.StatusCode) {
+			return retryable(err)
+		}
+		return err
 	}
 
 	return nil
 }
+
+func (p *haPusher) Close() error {
+	if p.ws != nil {
+		return p.ws.Close()
+	}
+	return nil
+}
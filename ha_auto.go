@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// generatedEntity is an entity made up for a sensor that has no
+// entry in sensorMetaMap.
+type generatedEntity struct {
+	Address string
+	Driver  string
+	Meta    sensorMeta
+	// SensorMap is the SENSOR_MAP entry needed to pin the entity, if
+	// the sensor is only known by its position on the bus.
+	SensorMap string
+}
+
+// generateEntity derives a stable entity from the sensor's driver,
+// model and bus address rather than its ID, as unmapped 1-Wire
+// sensors are numbered by position on the bus. It returns the sensor
+// ID to pin the entity under: the sensor's own ID if SENSOR_MAP names
+// it, otherwise one derived from the address such as
+// "ds18b20_0316a2794cff".
+func generateEntity(s Sensor) (string, generatedEntity, bool) {
+	if s.Address == "" {
+		return "", generatedEntity{}, false
+	}
+
+	serial := s.Address
+	if s.Driver == "w1" {
+		_, serial, _ = strings.Cut(s.Address, "-")
+	}
+	model := s.Model
+	if model == "" {
+		model = s.Driver
+	}
+
+	meta := sensorMeta{
+		Unit:        "°C",
+		DeviceClass: "temperature",
+		Deadband:    0.2,
+		Heartbeat:   defaultHAHeartbeat,
+		Icon:        "mdi:thermometer",
+		Precision:   intPtr(1),
+		Extras:      defaultHAExtras,
+	}
+	if strings.HasSuffix(s.ID, "humidity") {
+		meta.Unit = "%"
+		meta.DeviceClass = "humidity"
+		meta.Deadband = 1
+		meta.Icon = "mdi:water-percent"
+	}
+
+	id := slug(model + "_" + serial)
+	meta.FriendlyName = model + " " + serial
+	// IIO devices report several quantities under one address.
+	if s.Driver != "w1" {
+		id += "_" + meta.DeviceClass
+		meta.FriendlyName += " " + meta.DeviceClass
+	}
+	meta.EntityID = "sensor." + id
+
+	gen := generatedEntity{Address: s.Address, Driver: s.Driver, Meta: meta}
+	if _, err := strconv.Atoi(s.ID); err != nil || s.Driver != "w1" {
+		return s.ID, gen, true
+	}
+	gen.SensorMap = s.Address + ":" + id
+	return id, gen, true
+}
+
+// slug lowercases s and replaces everything but letters and digits
+// with underscores.
+func slug(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, s)
+}
+
+// generatedEntity returns the generated entity for an unmapped
+// sensor and the ID it is kept under in generated, logging the mapping
+// the first time it is seen.
+func (p *haPusher) generatedEntity(s Sensor) (string, sensorMeta, bool) {
+	id, gen, ok := generateEntity(s)
+	if !ok {
+		return "", sensorMeta{}, false
+	}
+
+	p.genMu.Lock()
+	defer p.genMu.Unlock()
+	if existing, ok := p.generated[id]; ok {
+		return id, existing.Meta, true
+	}
+	p.generated[id] = gen
+	log.Printf("ha: generated %s (%q) for unmapped %s %s, see /ha/generated to pin it",
+		gen.Meta.EntityID, gen.Meta.FriendlyName, gen.Driver, gen.Address)
+	return id, gen.Meta, true
+}
+
+// handleGenerated prints the generated entities as a SENSOR_MAP value
+// and an HA_ENTITIES file so they can be pinned.
+func (p *haPusher) handleGenerated(w http.ResponseWriter, r *http.Request) {
+	p.genMu.Lock()
+	var sensorMap []string
+	entities := make(map[string]sensorMeta, len(p.generated))
+	for id, gen := range p.generated {
+		entities[id] = gen.Meta
+		if gen.SensorMap != "" {
+			sensorMap = append(sensorMap, gen.SensorMap)
+		}
+	}
+	p.genMu.Unlock()
+	sort.Strings(sensorMap)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(struct {
+		SensorMap string                `json:"sensor_map"`
+		Entities  map[string]sensorMeta `json:"entities"`
+	}{strings.Join(sensorMap, ","), entities})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGenerateEntity(t *testing.T) {
+	id, gen, ok := generateEntity(Sensor{ID: "2", Address: "28-0316a2794cff", Driver: "w1", Model: "DS18B20"})
+	if !ok || id != "ds18b20_0316a2794cff" {
+		t.Fatalf("id = %q, %v", id, ok)
+	}
+	m := gen.Meta
+	if m.EntityID != "sensor.ds18b20_0316a2794cff" || m.FriendlyName != "DS18B20 0316a2794cff" ||
+		m.Unit != "°C" || m.DeviceClass != "temperature" {
+		t.Errorf("meta = %+v", m)
+	}
+	if gen.SensorMap != "28-0316a2794cff:ds18b20_0316a2794cff" {
+		t.Errorf("sensor map = %q", gen.SensorMap)
+	}
+
+	// A sensor named in SENSOR_MAP is pinned under its own ID.
+	id, gen, _ = generateEntity(Sensor{ID: "garage", Address: "28-0316a2794cff", Driver: "w1", Model: "DS18B20"})
+	if id != "garage" || gen.SensorMap != "" || gen.Meta.EntityID != "sensor.ds18b20_0316a2794cff" {
+		t.Errorf("named sensor: id = %q, gen = %+v", id, gen)
+	}
+
+	id, gen, _ = generateEntity(Sensor{ID: "utility_room_humidity", Address: "iio:device0", Driver: "iio", Model: "DHT22"})
+	if id != "utility_room_humidity" || gen.Meta.EntityID != "sensor.dht22_iio_device0_humidity" ||
+		gen.Meta.Unit != "%" || gen.Meta.DeviceClass != "humidity" {
+		t.Errorf("iio: id = %q, meta = %+v", id, gen.Meta)
+	}
+
+	if _, _, ok := generateEntity(Sensor{ID: "0"}); ok {
+		t.Error("generated an entity for a sensor without address")
+	}
+}
+
+func TestPush_AutoEntities(t *testing.T) {
+	var mu sync.Mutex
+	paths := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	p := NewHAPusher(ts.URL, "test-token")
+	p.autoEntities = true
+	probe := Sensor{ID: "0", Value: "20.5", Address: "28-0316a2794cff", Driver: "w1", Model: "DS18B20"}
+	p.Push([]Sensor{probe})
+	probe.Value = "30.5"
+	p.Push([]Sensor{probe})
+
+	mu.Lock()
+	n := paths["/api/states/sensor.ds18b20_0316a2794cff"]
+	mu.Unlock()
+	if n != 2 {
+		t.Errorf("pushes to generated entity = %d, want 2 (paths %v)", n, paths)
+	}
+	if len(p.generated) != 1 {
+		t.Errorf("generated = %d, want 1", len(p.generated))
+	}
+
+	rec := httptest.NewRecorder()
+	p.handleGenerated(rec, httptest.NewRequest("GET", "/ha/generated", nil))
+	var body struct {
+		SensorMap string                     `json:"sensor_map"`
+		Entities  map[string]json.RawMessage `json:"entities"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.SensorMap != "28-0316a2794cff:ds18b20_0316a2794cff" {
+		t.Errorf("sensor_map = %q", body.SensorMap)
+	}
+
+	// The printed entities are a valid HA_ENTITIES file.
+	saved := sensorMetaMap
+	sensorMetaMap = make(map[string]sensorMeta)
+	defer func() { sensorMetaMap = saved }()
+	path := filepath.Join(t.TempDir(), "entities.json")
+	data, _ := json.Marshal(body.Entities)
+	os.WriteFile(path, data, 0644)
+	if err := LoadEntityConfig(path); err != nil {
+		t.Fatalf("load generated config: %v", err)
+	}
+	pinned := sensorMetaMap["ds18b20_0316a2794cff"]
+	if pinned.EntityID != "sensor.ds18b20_0316a2794cff" || pinned.Heartbeat != defaultHAHeartbeat {
+		t.Errorf("pinned = %+v", pinned)
+	}
+}
+
+func TestPush_AutoEntityUnavailable(t *testing.T) {
+	var mu sync.Mutex
+	states := make(map[string][]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload haPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		states[r.URL.Path] = append(states[r.URL.Path], payload.State)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	pushed := func(entityID string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), states["/api/states/"+entityID]...)
+	}
+
+	p := NewHAPusher(ts.URL, "test-token")
+	p.autoEntities = true
+	p.unavailableAfter = 30 * time.Millisecond
+	first := Sensor{ID: "0", Value: "20.0", Address: "28-0316a2794cff", Driver: "w1", Model: "DS18B20"}
+	second := Sensor{ID: "1", Value: "40.0", Address: "28-0417b1a3f0aa", Driver: "w1", Model: "DS18B20"}
+	p.Push([]Sensor{first, second})
+
+	// The first probe drops off the bus and the second one takes over
+	// its positional ID.
+	time.Sleep(40 * time.Millisecond)
+	second.ID = "0"
+	p.Push([]Sensor{second})
+
+	if got := pushed("sensor.ds18b20_0316a2794cff"); len(got) != 2 || got[1] != "unavailable" {
+		t.Errorf("missing probe states = %v, want 20.0 then unavailable", got)
+	}
+	if got := pushed("sensor.ds18b20_0417b1a3f0aa"); len(got) != 1 || got[0] != "40.0" {
+		t.Errorf("remaining probe states = %v, want one 40.0 (unchanged since)", got)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHAQueueSize     = 1000
+	defaultHAQueueCoalesce = 300
+)
+
+// haQueuedState is a state update that could not be delivered.
+type haQueuedState struct {
+	EntityID string    `json:"entity_id"`
+	Payload  haPayload `json:"payload"`
+	Queued   time.Time `json:"queued"`
+
+	value float64 // parsed reading, for the push policy
+}
+
+// haQueue holds undelivered state updates for replay, oldest first.
+// It never grows beyond max entries; a backlog of more than
+// coalesceAbove entries is reduced to the newest state per entity
+// before it is replayed. If path is set, the queue is written there
+// as JSON whenever it changes so it survives restarts.
+type haQueue struct {
+	path          string
+	max           int
+	coalesceAbove int
+
+	mu    sync.Mutex
+	items []haQueuedState
+	dirty bool
+}
+
+func newHAQueue() *haQueue {
+	return &haQueue{
+		max:           defaultHAQueueSize,
+		coalesceAbove: defaultHAQueueCoalesce,
+	}
+}
+
+// Load reads a previously saved queue from path and persists to it
+// from now on. A missing file is not an error.
+func (q *haQueue) Load(path string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &q.items); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(q.items) > 0 {
+		log.Printf("ha: loaded %d queued states from %s", len(q.items), path)
+	}
+	return nil
+}
+
+func (q *haQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Add appends a state. When the queue is full it is coalesced, and if
+// that is not enough the oldest entries are dropped.
+func (q *haQueue) Add(entityID string, payload haPayload) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.items = append(q.items, haQueuedState{
+		EntityID: entityID,
+		Payload:  payload,
+		Queued:   time.Now(),
+	})
+	q.dirty = true
+
+	if len(q.items) > q.max {
+		q.coalesce()
+	}
+	if over := len(q.items) - q.max; over > 0 {
+		q.items = q.items[over:]
+		log.Printf("ha: queue full, dropped %d oldest states", over)
+	}
+}
+
+// Front returns the oldest queued state.
+func (q *haQueue) Front() (haQueuedState, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return haQueuedState{}, false
+	}
+	return q.items[0], true
+}
+
+// Pop removes the oldest queued state.
+func (q *haQueue) Pop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) > 0 {
+		q.items = q.items[1:]
+		q.dirty = true
+	}
+}
+
+// CoalesceIfLarge reduces a backlog of more than coalesceAbove entries
+// to the newest state per entity and returns the number dropped.
+func (q *haQueue) CoalesceIfLarge() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) <= q.coalesceAbove {
+		return 0
+	}
+	return q.coalesce()
+}
+
+// coalesce keeps only the newest state per entity, preserving the
+// order of the survivors. The caller holds q.mu.
+func (q *haQueue) coalesce() int {
+	newest := make(map[string]int, len(q.items))
+	for i, item := range q.items {
+		newest[item.EntityID] = i
+	}
+	kept := make([]haQueuedState, 0, len(newest))
+	for i, item := range q.items {
+		if newest[item.EntityID] == i {
+			kept = append(kept, item)
+		}
+	}
+	dropped := len(q.items) - len(kept)
+	if dropped > 0 {
+		q.items = kept
+		q.dirty = true
+	}
+	return dropped
+}
+
+// Save writes the queue to disk if it changed since the last save.
+// An empty queue removes the file.
+func (q *haQueue) Save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.path == "" || !q.dirty {
+		return nil
+	}
+	q.dirty = false
+
+	if len(q.items) == 0 {
+		err := os.Remove(q.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	data, err := json.Marshal(q.items)
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
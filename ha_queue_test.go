@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHAPusher_QueueReplayInOrder(t *testing.T) {
+	var mu sync.Mutex
+	down := true
+	var states []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var payload haPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		states = append(states, payload.State)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	p := NewHAPusher(ts.URL, "test-token")
+	p.Push([]Sensor{{ID: "hot_water_middle", Value: "41"}})
+	p.Push([]Sensor{{ID: "hot_water_middle", Value: "42"}})
+	if n := p.Backlog(); n != 2 {
+		t.Fatalf("backlog = %d, want 2", n)
+	}
+
+	d := newDispatcher()
+	d.Add(p, 1, dropOldest)
+	defer d.Close(time.Second)
+	if n, ok := d.Backlog("homeassistant"); !ok || n != 2 {
+		t.Errorf("dispatcher backlog = %d, %v, want 2, true", n, ok)
+	}
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	if err := p.Push([]Sensor{{ID: "hot_water_middle", Value: "43"}}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != 3 || states[0] != "41.0" || states[1] != "42.0" || states[2] != "43.0" {
+		t.Errorf("states = %v, want [41.0 42.0 43.0]", states)
+	}
+	if n := p.Backlog(); n != 0 {
+		t.Errorf("backlog = %d, want 0", n)
+	}
+	if p.failures != 0 {
+		t.Errorf("failures = %d, want 0", p.failures)
+	}
+}
+
+func TestHAPusher_ClientErrorNotQueued(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	p := NewHAPusher(ts.URL, "test-token")
+	if err := p.Push([]Sensor{{ID: "hot_water_middle", Value: "41"}}); err == nil {
+		t.Error("expected error")
+	}
+	if n := p.Backlog(); n != 0 {
+		t.Errorf("backlog = %d, want 0 for non-retryable error", n)
+	}
+}
+
+func TestHAQueue_Coalesce(t *testing.T) {
+	q := newHAQueue()
+	q.coalesceAbove = 2
+	q.Add("sensor.a", haPayload{State: "1"})
+	q.Add("sensor.b", haPayload{State: "1"})
+	if dropped := q.CoalesceIfLarge(); dropped != 0 {
+		t.Errorf("dropped = %d at threshold, want 0", dropped)
+	}
+	q.Add("sensor.a", haPayload{State: "2"})
+
+	if dropped := q.CoalesceIfLarge(); dropped != 1 {
+		t.Errorf("dropped = %d, want 1", dropped)
+	}
+	var got []string
+	for item, ok := q.Front(); ok; item, ok = q.Front() {
+		got = append(got, item.EntityID+"="+item.Payload.State)
+		q.Pop()
+	}
+	if len(got) != 2 || got[0] != "sensor.b=1" || got[1] != "sensor.a=2" {
+		t.Errorf("queue = %v, want [sensor.b=1 sensor.a=2]", got)
+	}
+}
+
+func TestHAQueue_Bounded(t *testing.T) {
+	q := newHAQueue()
+	q.max = 3
+	q.Add("sensor.a", haPayload{State: "1"})
+	q.Add("sensor.a", haPayload{State: "2"})
+	q.Add("sensor.b", haPayload{State: "1"})
+	q.Add("sensor.c", haPayload{State: "1"})
+
+	// Coalescing sensor.a makes room without dropping other entities.
+	if q.Len() != 3 {
+		t.Fatalf("len = %d, want 3", q.Len())
+	}
+	if item, _ := q.Front(); item.EntityID != "sensor.a" || item.Payload.State != "2" {
+		t.Errorf("front = %+v, want newest sensor.a", item)
+	}
+
+	q.Add("sensor.d", haPayload{State: "1"})
+	if item, _ := q.Front(); q.Len() != 3 || item.EntityID != "sensor.b" {
+		t.Errorf("len = %d, front = %s, want 3 entries starting at sensor.b", q.Len(), item.EntityID)
+	}
+}
+
+func TestHAQueue_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ha-queue.json")
+
+	q := newHAQueue()
+	if err := q.Load(path); err != nil {
+		t.Fatalf("load missing file: %v", err)
+	}
+	q.Add("sensor.warmwasser_mitte", haPayload{State: "48.8", Attributes: map[string]any{"unit_of_measurement": "°C"}})
+	if err := q.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	restored := newHAQueue()
+	if err := restored.Load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	item, ok := restored.Front()
+	if !ok || item.EntityID != "sensor.warmwasser_mitte" || item.Payload.State != "48.8" ||
+		item.Payload.Attributes["unit_of_measurement"] != "°C" {
+		t.Errorf("restored = %+v", item)
+	}
+
+	restored.Pop()
+	if err := restored.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("queue file still present after draining: %v", err)
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -99,44 +102,6 @@ func TestPush_Unreachable(t *testing.T) {
 	}
 }
 
-func TestPush_SkipWhenBusy(t *testing.T) {
-	blocked := make(chan struct{})
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		<-blocked
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer ts.Close()
-
-	p := NewHAPusher(ts.URL, "test-token")
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		p.Push([]Sensor{{ID: "hot_water_middle", Value: "48.750"}})
-	}()
-
-	time.Sleep(50 * time.Millisecond)
-
-	requestCount := 0
-	p.Push([]Sensor{{ID: "hot_water_middle", Value: "48.750"}})
-
-	close(blocked)
-	wg.Wait()
-
-	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestCount++
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer ts2.Close()
-	p.url = ts2.URL
-	p.Push([]Sensor{{ID: "hot_water_middle", Value: "48.750"}})
-
-	if requestCount != 1 {
-		t.Errorf("requestCount = %d, want 1 (second push should have been skipped)", requestCount)
-	}
-}
-
 func TestPush_UnknownSensors(t *testing.T) {
 	requestCount := 0
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -173,8 +138,11 @@ func TestPush_EmptySensors(t *testing.T) {
 }
 
 func TestPush_AllSixSensors(t *testing.T) {
+	var mu sync.Mutex
 	paths := make(map[string]bool)
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
 		paths[r.URL.Path] = true
 		w.WriteHeader(http.StatusOK)
 	}))
@@ -261,3 +229,207 @@ func TestPush_FailureRecovery(t *testing.T) {
 		t.Errorf("after recovery: failures = %d, want 0", p.failures)
 	}
 }
+
+func TestPush_DeadbandAndHeartbeat(t *testing.T) {
+	var mu sync.Mutex
+	var states []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload haPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		states = append(states, payload.State)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	p := NewHAPusher(ts.URL, "test-token")
+	for _, v := range []string{"48.0", "48.1", "48.2", "48.25", "47.9"} {
+		p.Push([]Sensor{{ID: "hot_water_middle", Value: v}})
+	}
+
+	// 48.1 and 48.2 are within the 0.2°C deadband of 48.0.
+	mu.Lock()
+	got := append([]string(nil), states...)
+	mu.Unlock()
+	if len(got) != 3 || got[0] != "48.0" || got[1] != "48.2" || got[2] != "47.9" {
+		t.Errorf("states = %v, want [48.0 48.2 47.9]", got)
+	}
+
+	// An unchanged reading is pushed once the heartbeat is due.
+	last := p.lastSent["sensor.warmwasser_mitte"]
+	last.at = last.at.Add(-defaultHAHeartbeat)
+	p.lastSent["sensor.warmwasser_mitte"] = last
+	p.Push([]Sensor{{ID: "hot_water_middle", Value: "47.9"}})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != 4 {
+		t.Errorf("states = %v, want heartbeat push", states)
+	}
+}
+
+func TestPush_DeadbandWithoutHeartbeat(t *testing.T) {
+	saved := sensorMetaMap
+	sensorMetaMap = make(map[string]sensorMeta)
+	defer func() { sensorMetaMap = saved }()
+	path := filepath.Join(t.TempDir(), "entities.json")
+	os.WriteFile(path, []byte(`{
+		"garage": {"entity_id": "sensor.garage", "deadband": 5},
+		"shed": {"entity_id": "sensor.shed", "deadband": 5, "heartbeat": "0"}
+	}`), 0644)
+	if err := LoadEntityConfig(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	var mu sync.Mutex
+	pushes := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		pushes[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	p := NewHAPusher(ts.URL, "test-token")
+	for _, v := range []string{"20.0", "20.1", "20.2"} {
+		p.Push([]Sensor{{ID: "garage", Value: v}, {ID: "shed", Value: v}})
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if pushes["/api/states/sensor.garage"] != 1 || pushes["/api/states/sensor.shed"] != 1 {
+		t.Errorf("pushes = %v, want one per entity within the deadband", pushes)
+	}
+}
+
+func TestLoadEntityConfig(t *testing.T) {
+	saved := sensorMetaMap
+	sensorMetaMap = make(map[string]sensorMeta)
+	for k, v := range saved {
+		sensorMetaMap[k] = v
+	}
+	defer func() { sensorMetaMap = saved }()
+
+	path := filepath.Join(t.TempDir(), "entities.json")
+	os.WriteFile(path, []byte(`{
+		"hot_water_middle": {"deadband": 0.5, "heartbeat": "15m"},
+		"garage": {"entity_id": "sensor.garage", "friendly_name": "Garage",
+		           "unit": "°C", "device_class": "temperature"}
+	}`), 0644)
+
+	if err := LoadEntityConfig(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	m := sensorMetaMap["hot_water_middle"]
+	if m.EntityID != "sensor.warmwasser_mitte" || m.Deadband != 0.5 || m.Heartbeat != 15*time.Minute {
+		t.Errorf("hot_water_middle = %+v, want built-in entity with overridden policy", m)
+	}
+	if g := sensorMetaMap["garage"]; g.EntityID != "sensor.garage" || g.Heartbeat != defaultHAHeartbeat {
+		t.Errorf("garage = %+v", g)
+	}
+
+	os.WriteFile(path, []byte(`{"heating_supply": {"precision": 2, "extra_attributes": ["last_read"]}}`), 0644)
+	if err := LoadEntityConfig(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if *saved["heating_supply"].Precision != 1 || len(defaultHAExtras) != 3 || defaultHAExtras[0] != "address" {
+		t.Error("loading entity config modified the built-in defaults")
+	}
+
+	os.WriteFile(path, []byte(`{"garage": {"extra_attributes": ["firmware"]}}`), 0644)
+	if err := LoadEntityConfig(path); err == nil {
+		t.Error("expected error for unknown extra attribute")
+	}
+	os.WriteFile(path, []byte(`{"shed": {"friendly_name": "Shed"}}`), 0644)
+	if err := LoadEntityConfig(path); err == nil {
+		t.Error("expected error for entry without entity_id")
+	}
+	os.WriteFile(path, []byte(`{"garage": {"heartbeat": "soon"}}`), 0644)
+	if err := LoadEntityConfig(path); err == nil {
+		t.Error("expected error for invalid heartbeat")
+	}
+}
+
+func TestPush_UnavailableAndRestore(t *testing.T) {
+	var mu sync.Mutex
+	var got []haPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload haPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		got = append(got, payload)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	payloads := func() []haPayload {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]haPayload(nil), got...)
+	}
+
+	p := NewHAPusher(ts.URL, "test-token")
+	p.unavailableAfter = 30 * time.Millisecond
+
+	p.Push([]Sensor{{ID: "heating_return", Value: "38.125"}})
+	time.Sleep(40 * time.Millisecond)
+	readErrors.record("heating_return", errors.New("CRC check failed"))
+	p.Push(nil)
+	p.Push(nil)
+
+	if n := len(payloads()); n != 2 {
+		t.Fatalf("pushes = %d, want 2 (unavailable sent once)", n)
+	}
+	u := payloads()[1]
+	if u.State != "unavailable" || u.Attributes["last_error"] != "CRC check failed" || u.Attributes["last_seen"] == "" {
+		t.Errorf("unavailable payload = %+v", u)
+	}
+
+	// The same value as before the outage is pushed despite the deadband.
+	p.Push([]Sensor{{ID: "heating_return", Value: "38.125"}})
+	if all := payloads(); len(all) != 3 || all[2].State != "38.1" {
+		t.Errorf("payloads = %+v, want restored state", all)
+	}
+}
+
+func TestPush_ExtraAttributes(t *testing.T) {
+	var gotPayload haPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotPayload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	p := NewHAPusher(ts.URL, "test-token")
+	p.Push([]Sensor{{
+		ID: "hot_water_middle", Value: "48.750",
+		Address: "28-0316a2794cff", Model: "DS18B20", Resolution: 12,
+	}})
+
+	want := map[string]any{
+		"icon":                        "mdi:water-boiler",
+		"suggested_display_precision": 1.0,
+		"address":                     "28-0316a2794cff",
+		"model":                       "DS18B20",
+		"resolution":                  12.0,
+	}
+	for k, v := range want {
+		if gotPayload.Attributes[k] != v {
+			t.Errorf("%s = %v, want %v", k, gotPayload.Attributes[k], v)
+		}
+	}
+	if _, ok := gotPayload.Attributes["last_read"]; ok {
+		t.Error("last_read sent without being configured")
+	}
+
+	meta := sensorMetaMap["hot_water_middle"]
+	meta.Area = "Keller"
+	meta.Extras = []string{"last_read", "read_errors"}
+	meta.Attributes = map[string]string{"manufacturer": "Maxim"}
+	readAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	payload := statePayload(Sensor{ID: "no_errors_yet", ReadAt: readAt}, 48.75, meta)
+	if a := payload.Attributes; a["area"] != "Keller" || a["last_read"] != "2024-01-01T12:00:00Z" ||
+		a["read_errors"] != 0 || a["manufacturer"] != "Maxim" || a["address"] != nil {
+		t.Errorf("attributes = %v", a)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHAWSEventType    = "tempsensorserver_state"
+	defaultHAWSPingInterval = 30 * time.Second
+	defaultHAWSPongTimeout  = 10 * time.Second
+	defaultHAWSMinBackoff   = time.Second
+	defaultHAWSMaxBackoff   = 5 * time.Minute
+	haWSCommandTimeout      = 5 * time.Second
+)
+
+// haWebSocket is a long-lived connection to the Home Assistant
+// WebSocket API (/api/websocket). It authenticates once and then
+// sends every state update over the same connection.
+//
+// Home Assistant has no WebSocket command that writes an entity state
+// directly, so updates are sent as fire_event commands with event
+// type eventType and data {entity_id, state, attributes}; a
+// trigger-based template sensor in HA turns them into entities (see
+// README).
+type haWebSocket struct {
+	url          string
+	token        string
+	eventType    string
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+
+	mu          sync.Mutex
+	conn        *haWSConn
+	backoff     time.Duration
+	nextAttempt time.Time
+}
+
+type haWSConn struct {
+	ws   *wsConn
+	dead chan struct{}
+	once sync.Once
+
+	mu       sync.Mutex
+	nextID   int
+	pending  map[int]chan haWSMessage
+	lastPong time.Time
+}
+
+type haWSMessage struct {
+	ID      int    `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Success bool   `json:"success,omitempty"`
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// NewHAWebSocket derives the WebSocket endpoint from the HA base URL
+// (http→ws, https→wss).
+func NewHAWebSocket(baseURL, token string) *haWebSocket {
+	wsURL := strings.TrimRight(baseURL, "/") + "/api/websocket"
+	switch {
+	case strings.HasPrefix(wsURL, "https://"):
+		wsURL = "wss://" + strings.TrimPrefix(wsURL, "https://")
+	case strings.HasPrefix(wsURL, "http://"):
+		wsURL = "ws://" + strings.TrimPrefix(wsURL, "http://")
+	}
+	return &haWebSocket{
+		url:          wsURL,
+		token:        token,
+		eventType:    defaultHAWSEventType,
+		pingInterval: defaultHAWSPingInterval,
+		pongTimeout:  defaultHAWSPongTimeout,
+		minBackoff:   defaultHAWSMinBackoff,
+		maxBackoff:   defaultHAWSMaxBackoff,
+	}
+}
+
+// SetState sends one state update, connecting first if needed.
+// Connection failures are retryable; an error result from HA is not.
+func (h *haWebSocket) SetState(entityID string, payload haPayload) error {
+	c, err := h.connection()
+	if err != nil {
+		return retryable(err)
+	}
+
+	msg, err := c.command(map[string]any{
+		"type":       "fire_event",
+		"event_type": h.eventType,
+		"event_data": map[string]any{
+			"entity_id":  entityID,
+			"state":      payload.State,
+			"attributes": payload.Attributes,
+		},
+	})
+	if err != nil && c.isDead() {
+		h.drop(c)
+	}
+	if err != nil && msg.Type != "result" {
+		return retryable(err)
+	}
+	return err
+}
+
+func (h *haWebSocket) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn == nil {
+		return nil
+	}
+	h.conn.ws.WriteMessage(wsClose, nil)
+	h.conn.close()
+	h.conn = nil
+	return nil
+}
+
+func (h *haWebSocket) connection() (*haWSConn, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil && !h.conn.isDead() {
+		return h.conn, nil
+	}
+	h.conn = nil
+
+	if wait := time.Until(h.nextAttempt); wait > 0 {
+		return nil, fmt.Errorf("websocket not connected, next attempt in %s", wait.Round(time.Second))
+	}
+
+	c, err := h.connect()
+	if err != nil {
+		if h.backoff == 0 {
+			h.backoff = h.minBackoff
+		} else {
+			h.backoff = min(h.backoff*2, h.maxBackoff)
+		}
+		h.nextAttempt = time.Now().Add(h.backoff)
+		return nil, err
+	}
+
+	h.backoff = 0
+	h.conn = c
+	log.Printf("ha: websocket connected to %s", h.url)
+	return c, nil
+}
+
+func (h *haWebSocket) drop(c *haWSConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn == c {
+		h.conn = nil
+	}
+}
+
+// connect dials and performs the auth handshake:
+// auth_required → auth → auth_ok / auth_invalid.
+func (h *haWebSocket) connect() (*haWSConn, error) {
+	ws, err := dialWebSocket(h.url, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("websocket dial: %w", err)
+	}
+
+	ws.conn.SetReadDeadline(time.Now().Add(haWSCommandTimeout))
+	msg, err := readHAWSMessage(ws)
+	if err != nil || msg.Type != "auth_required" {
+		ws.Close()
+		return nil, fmt.Errorf("websocket auth: expected auth_required, got %q (%v)", msg.Type, err)
+	}
+
+	auth, _ := json.Marshal(map[string]string{"type": "auth", "access_token": h.token})
+	if err := ws.WriteMessage(wsText, auth); err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("websocket auth: %w", err)
+	}
+
+	msg, err = readHAWSMessage(ws)
+	if err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("websocket auth: %w", err)
+	}
+	if msg.Type != "auth_ok" {
+		ws.Close()
+		return nil, fmt.Errorf("websocket auth failed: %s %s", msg.Type, msg.Message)
+	}
+	ws.conn.SetReadDeadline(time.Time{})
+
+	c := &haWSConn{
+		ws:       ws,
+		dead:     make(chan struct{}),
+		pending:  make(map[int]chan haWSMessage),
+		lastPong: time.Now(),
+	}
+	go c.readLoop()
+	go c.pingLoop(h.pingInterval, h.pongTimeout)
+	return c, nil
+}
+
+func readHAWSMessage(ws *wsConn) (haWSMessage, error) {
+	var msg haWSMessage
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		return msg, err
+	}
+	err = json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// command sends cmd with a fresh ID and waits for the matching result.
+func (c *haWSConn) command(cmd map[string]any) (haWSMessage, error) {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	reply := make(chan haWSMessage, 1)
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	cmd["id"] = id
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return haWSMessage{}, err
+	}
+	if err := c.ws.WriteMessage(wsText, data); err != nil {
+		c.close()
+		return haWSMessage{}, fmt.Errorf("websocket write: %w", err)
+	}
+
+	timer := time.NewTimer(haWSCommandTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-reply:
+		if msg.Type == "result" && !msg.Success {
+			if msg.Error != nil {
+				return msg, fmt.Errorf("%s: %s", msg.Error.Code, msg.Error.Message)
+			}
+			return msg, errors.New("command failed")
+		}
+		return msg, nil
+	case <-c.dead:
+		return haWSMessage{}, errors.New("websocket connection lost")
+	case <-timer.C:
+		return haWSMessage{}, errors.New("websocket command timed out")
+	}
+}
+
+func (c *haWSConn) readLoop() {
+	defer c.close()
+	for {
+		msg, err := readHAWSMessage(c.ws)
+		if err != nil {
+			return
+		}
+
+		c.mu.Lock()
+		if msg.Type == "pong" {
+			c.lastPong = time.Now()
+		}
+		reply, ok := c.pending[msg.ID]
+		c.mu.Unlock()
+		if ok {
+			reply <- msg
+		}
+	}
+}
+
+// pingLoop sends an HA-level ping every interval and closes the
+// connection if no pong arrived within timeout of the last ping.
+func (c *haWSConn) pingLoop(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			since := time.Since(c.lastPong)
+			c.mu.Unlock()
+			if since > interval+timeout {
+				log.Printf("ha: websocket pong overdue by %s, reconnecting", (since - interval).Round(time.Millisecond))
+				c.close()
+				return
+			}
+			go c.command(map[string]any{"type": "ping"})
+		case <-c.dead:
+			return
+		}
+	}
+}
+
+func (c *haWSConn) close() {
+	c.once.Do(func() {
+		close(c.dead)
+		c.ws.Close()
+	})
+}
+
+func (c *haWSConn) isDead() bool {
+	select {
+	case <-c.dead:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHAWS emulates the Home Assistant WebSocket API: the auth
+// handshake, ping/pong and fire_event results.
+type fakeHAWS struct {
+	token       string
+	ignorePings bool
+
+	mu       sync.Mutex
+	connects int
+	events   []map[string]any
+	conns    []*wsConn
+}
+
+func newFakeHAWS(t *testing.T, token string) (*httptest.Server, *fakeHAWS) {
+	f := &fakeHAWS{token: token}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/websocket" {
+			http.NotFound(w, r)
+			return
+		}
+		ws, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		f.serve(ws)
+	}))
+	t.Cleanup(func() {
+		f.dropAll()
+		ts.Close()
+	})
+	return ts, f
+}
+
+func (f *fakeHAWS) serve(ws *wsConn) {
+	defer ws.Close()
+	f.mu.Lock()
+	f.connects++
+	f.conns = append(f.conns, ws)
+	f.mu.Unlock()
+
+	send := func(v any) {
+		data, _ := json.Marshal(v)
+		ws.WriteMessage(wsText, data)
+	}
+
+	send(map[string]string{"type": "auth_required"})
+	var auth map[string]string
+	_, data, err := ws.ReadMessage()
+	if err != nil || json.Unmarshal(data, &auth) != nil {
+		return
+	}
+	if auth["type"] != "auth" || auth["access_token"] != f.token {
+		send(map[string]string{"type": "auth_invalid", "message": "Invalid access token"})
+		return
+	}
+	send(map[string]string{"type": "auth_ok"})
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var cmd map[string]any
+		json.Unmarshal(data, &cmd)
+		id := cmd["id"]
+
+		switch cmd["type"] {
+		case "ping":
+			f.mu.Lock()
+			ignore := f.ignorePings
+			f.mu.Unlock()
+			if !ignore {
+				send(map[string]any{"id": id, "type": "pong"})
+			}
+		case "fire_event":
+			f.mu.Lock()
+			f.events = append(f.events, cmd)
+			f.mu.Unlock()
+			send(map[string]any{"id": id, "type": "result", "success": true})
+		default:
+			send(map[string]any{"id": id, "type": "result", "success": false,
+				"error": map[string]string{"code": "unknown_command", "message": "Unknown command."}})
+		}
+	}
+}
+
+func (f *fakeHAWS) dropAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeHAWS) snapshot() (int, []map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connects, append([]map[string]any(nil), f.events...)
+}
+
+func TestNewHAWebSocket_URL(t *testing.T) {
+	cases := map[string]string{
+		"http://192.168.188.110:8123": "ws://192.168.188.110:8123/api/websocket",
+		"https://ha.example.com/":     "wss://ha.example.com/api/websocket",
+	}
+	for in, want := range cases {
+		if got := NewHAWebSocket(in, "t").url; got != want {
+			t.Errorf("url(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHAPusher_WebSocket(t *testing.T) {
+	ts, ha := newFakeHAWS(t, "test-token")
+
+	p := NewHAPusher(ts.URL, "test-token")
+	p.ws = NewHAWebSocket(ts.URL, "test-token")
+	defer p.Close()
+
+	sensors := []Sensor{
+		{ID: "hot_water_middle", Value: "48.750"},
+		{ID: "utility_room_humidity", Value: "49.3"},
+	}
+	if err := p.Push(sensors); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if err := p.Push([]Sensor{
+		{ID: "hot_water_middle", Value: "50.125"},
+		{ID: "utility_room_humidity", Value: "55.0"},
+	}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	connects, events := ha.snapshot()
+	if connects != 1 {
+		t.Errorf("connects = %d, want 1 (connection reused)", connects)
+	}
+	if len(events) != 4 {
+		t.Fatalf("events = %d, want 4", len(events))
+	}
+
+	// Entities are sent concurrently, so find the event by entity.
+	var data map[string]any
+	for _, ev := range events {
+		if ev["event_type"] != "tempsensorserver_state" {
+			t.Errorf("event_type = %v", ev["event_type"])
+		}
+		if d := ev["event_data"].(map[string]any); data == nil && d["entity_id"] == "sensor.warmwasser_mitte" {
+			data = d
+		}
+	}
+	if data == nil || data["state"] != "48.8" {
+		t.Fatalf("event_data = %v", data)
+	}
+	attrs := data["attributes"].(map[string]any)
+	if attrs["friendly_name"] != "Warmwasser Mitte" || attrs["unit_of_measurement"] != "°C" {
+		t.Errorf("attributes = %v", attrs)
+	}
+	if p.failures != 0 {
+		t.Errorf("failures = %d, want 0", p.failures)
+	}
+}
+
+func TestHAPusher_WebSocketAuthInvalid(t *testing.T) {
+	ts, _ := newFakeHAWS(t, "right-token")
+
+	p := NewHAPusher(ts.URL, "wrong-token")
+	p.ws = NewHAWebSocket(ts.URL, "wrong-token")
+
+	err := p.Push([]Sensor{{ID: "hot_water_middle", Value: "48.750"}})
+	if err == nil || !strings.Contains(err.Error(), "auth_invalid") {
+		t.Errorf("err = %v, want auth_invalid", err)
+	}
+	if p.failures != 1 {
+		t.Errorf("failures = %d, want 1", p.failures)
+	}
+}
+
+func TestHAWebSocket_ReconnectWithBackoff(t *testing.T) {
+	ts, ha := newFakeHAWS(t, "tok")
+
+	h := NewHAWebSocket(ts.URL, "tok")
+	h.minBackoff = 50 * time.Millisecond
+	defer h.Close()
+
+	payload := haPayload{State: "1.0", Attributes: map[string]any{}}
+	if err := h.SetState("sensor.x", payload); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+
+	ha.dropAll()
+	waitFor(t, func() bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.conn.isDead()
+	})
+
+	if err := h.SetState("sensor.x", payload); err != nil {
+		t.Fatalf("SetState after drop: %v", err)
+	}
+	if connects, _ := ha.snapshot(); connects != 2 {
+		t.Errorf("connects = %d, want 2", connects)
+	}
+}
+
+func TestHAWebSocket_BackoffWhenUnreachable(t *testing.T) {
+	h := NewHAWebSocket("http://127.0.0.1:1", "tok")
+	h.minBackoff = time.Hour
+
+	payload := haPayload{State: "1.0"}
+	if err := h.SetState("sensor.x", payload); err == nil {
+		t.Fatal("expected dial error")
+	}
+	err := h.SetState("sensor.x", payload)
+	if err == nil || !strings.Contains(err.Error(), "next attempt") {
+		t.Errorf("err = %v, want backoff error", err)
+	}
+}
+
+func TestHAWebSocket_PongTimeout(t *testing.T) {
+	ts, ha := newFakeHAWS(t, "tok")
+	ha.ignorePings = true
+
+	h := NewHAWebSocket(ts.URL, "tok")
+	h.pingInterval = 20 * time.Millisecond
+	h.pongTimeout = 10 * time.Millisecond
+	defer h.Close()
+
+	if err := h.SetState("sensor.x", haPayload{State: "1.0"}); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+
+	h.mu.Lock()
+	c := h.conn
+	h.mu.Unlock()
+	waitFor(t, c.isDead)
+}
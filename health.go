@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// defaultMaxPollAgeFactor bounds the age of the last poll, in poll
+// intervals, before the poll loop counts as stuck.
+const defaultMaxPollAgeFactor = 3
+
+// healthCheck is one entry in a /healthz or /readyz report.
+type healthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // "ok" or "fail"
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthReport struct {
+	Status string        `json:"status"` // "ok" if all checks passed, else "fail"
+	Checks []healthCheck `json:"checks"`
+}
+
+func check(name string, ok bool, format string, args ...any) healthCheck {
+	c := healthCheck{Name: name, Status: "ok", Detail: fmt.Sprintf(format, args...)}
+	if !ok {
+		c.Status = "fail"
+	}
+	return c
+}
+
+func (s *server) maxPollAge() time.Duration {
+	if s.maxAge > 0 {
+		return s.maxAge
+	}
+	return defaultMaxPollAgeFactor * s.pollInterval
+}
+
+// pollCheck fails when no poll has completed for longer than
+// maxPollAge, i.e. the poll loop is stuck or dead.
+func (s *server) pollCheck(now time.Time) healthCheck {
+	snap := s.snapshot()
+	if snap.Generation == 0 {
+		return check("poll", false, "no poll completed yet")
+	}
+	age := now.Sub(snap.Time).Round(time.Second)
+	if limit := s.maxPollAge(); limit > 0 && age > limit {
+		return check("poll", false, "last poll %s ago (limit %s)", age, limit)
+	}
+	return check("poll", true, "last poll %s ago, generation %d", age, snap.Generation)
+}
+
+// expectedSensors returns the IDs the configuration promises: every
+// SENSOR_MAP entry, plus the DHT22 readings if an IIO device was found.
+func (s *server) expectedSensors() []string {
+	var ids []string
+	for _, id := range s.sensorMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if s.iioPath != "" {
+		ids = append(ids, "utility_room_temperature", "utility_room_humidity")
+	}
+	return ids
+}
+
+// sensorChecks reports each expected sensor as present or missing in
+// the latest poll. Without configured sensors, any reading will do.
+func (s *server) sensorChecks() []healthCheck {
+	sensors := s.sensors()
+	expected := s.expectedSensors()
+	if len(expected) == 0 {
+		return []healthCheck{check("sensors", len(sensors) > 0, "%d sensors in last poll", len(sensors))}
+	}
+
+	values := make(map[string]string, len(sensors))
+	for _, sn := range sensors {
+		values[sn.ID] = sn.Value
+	}
+	var checks []healthCheck
+	for _, id := range expected {
+		if v, ok := values[id]; ok {
+			checks = append(checks, check("sensor:"+id, true, "%s", v))
+			continue
+		}
+		detail := "missing from last poll"
+		if e, ok := readErrors.Get(id); ok {
+			detail += ": " + e.Err
+		}
+		checks = append(checks, check("sensor:"+id, false, "%s", detail))
+	}
+	return checks
+}
+
+// haCheck fails if the last push to Home Assistant failed or its
+// circuit breaker is open. ok is false if HA push is disabled.
+func (s *server) haCheck() (healthCheck, bool) {
+	st, ok := s.outputs.SinkStatus("homeassistant")
+	if !ok {
+		return healthCheck{}, false
+	}
+	switch {
+	case st.Breaker != nil && st.Breaker.State == breakerOpen.String():
+		return check("homeassistant", false, "circuit open after %d failures", st.Breaker.Failures), true
+	case st.LastError != "":
+		return check("homeassistant", false, "%s", st.LastError), true
+	case st.LastSuccess == nil:
+		return check("homeassistant", true, "no push yet"), true
+	}
+	return check("homeassistant", true, "last push %s ago", time.Since(*st.LastSuccess).Round(time.Second)), true
+}
+
+// handleLiveness reports whether the process should be restarted: it
+// fails only if the poll loop has stopped producing results.
+func (s *server) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, []healthCheck{s.pollCheck(time.Now())})
+}
+
+// handleReadiness reports whether the data served is complete and
+// fresh and reaches Home Assistant.
+func (s *server) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	checks := []healthCheck{s.pollCheck(time.Now())}
+	checks = append(checks, s.sensorChecks()...)
+	if c, ok := s.haCheck(); ok {
+		checks = append(checks, c)
+	}
+	writeHealthReport(w, checks)
+}
+
+// writeHealthReport replies 200 if all checks passed, else 503.
+func writeHealthReport(w http.ResponseWriter, checks []healthCheck) {
+	report := healthReport{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, c := range checks {
+		if c.Status != "ok" {
+			report.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
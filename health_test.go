@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func healthOf(t *testing.T, h http.HandlerFunc) (int, healthReport) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/", nil))
+	var report healthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return rec.Code, report
+}
+
+func findCheck(report healthReport, name string) healthCheck {
+	for _, c := range report.Checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	return healthCheck{}
+}
+
+func TestLiveness(t *testing.T) {
+	srv := &server{pollInterval: 10 * time.Second}
+	if code, report := healthOf(t, srv.handleLiveness); code != http.StatusServiceUnavailable || report.Status != "fail" {
+		t.Errorf("before first poll: %d %+v", code, report)
+	}
+
+	srv.store(reading("48.750"))
+	if code, report := healthOf(t, srv.handleLiveness); code != http.StatusOK || report.Checks[0].Status != "ok" {
+		t.Errorf("fresh poll: %d %+v", code, report)
+	}
+
+	// A poll loop that stopped three intervals ago is dead.
+	srv.cache.Store(snapshot{Sensors: reading("48.750"), Generation: 1, Time: time.Now().Add(-31 * time.Second)})
+	if code, report := healthOf(t, srv.handleLiveness); code != http.StatusServiceUnavailable || report.Checks[0].Name != "poll" {
+		t.Errorf("stale poll: %d %+v", code, report)
+	}
+	srv.maxAge = time.Minute
+	if code, _ := healthOf(t, srv.handleLiveness); code != http.StatusOK {
+		t.Errorf("stale poll within HEALTH_MAX_POLL_AGE: %d", code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	srv := &server{
+		pollInterval: 10 * time.Second,
+		sensorMap:    map[string]string{"28-000000000001": "hot_water_middle", "28-000000000002": "heating_supply"},
+		iioPath:      "testdata/iio_device",
+	}
+	srv.store([]Sensor{
+		{ID: "hot_water_middle", Value: "48.750"},
+		{ID: "utility_room_temperature", Value: "21.3"},
+		{ID: "utility_room_humidity", Value: "49.3"},
+	})
+
+	code, report := healthOf(t, srv.handleReadiness)
+	if code != http.StatusServiceUnavailable || report.Status != "fail" || len(report.Checks) != 5 {
+		t.Fatalf("missing sensor: %d %+v", code, report)
+	}
+	if c := findCheck(report, "sensor:heating_supply"); c.Status != "fail" || c.Detail == "" {
+		t.Errorf("heating_supply = %+v", c)
+	}
+	if c := findCheck(report, "sensor:hot_water_middle"); c.Status != "ok" || c.Detail != "48.750" {
+		t.Errorf("hot_water_middle = %+v", c)
+	}
+
+	srv.store([]Sensor{
+		{ID: "hot_water_middle", Value: "48.750"},
+		{ID: "heating_supply", Value: "22.875"},
+		{ID: "utility_room_temperature", Value: "21.3"},
+		{ID: "utility_room_humidity", Value: "49.3"},
+	})
+	if code, report := healthOf(t, srv.handleReadiness); code != http.StatusOK {
+		t.Errorf("all present: %d %+v", code, report)
+	}
+}
+
+func TestReadiness_HomeAssistant(t *testing.T) {
+	out := newFakeOutput("homeassistant")
+	d := newDispatcher()
+	d.Add(out, 10, dropOldest)
+	defer d.Close(time.Second)
+
+	srv := &server{pollInterval: 10 * time.Second, outputs: d}
+	srv.store(reading("48.750"))
+	if _, report := healthOf(t, srv.handleReadiness); findCheck(report, "homeassistant").Status != "ok" {
+		t.Errorf("before first push: %+v", report)
+	}
+
+	out.err = errors.New("connection refused")
+	d.Dispatch(reading("48.750"))
+	waitFor(t, func() bool {
+		st, _ := d.SinkStatus("homeassistant")
+		return st.Failed == 1
+	})
+	code, report := healthOf(t, srv.handleReadiness)
+	if c := findCheck(report, "homeassistant"); code != http.StatusServiceUnavailable || c.Status != "fail" || c.Detail != "connection refused" {
+		t.Errorf("failed push: %d %+v", code, report)
+	}
+	// Liveness does not depend on Home Assistant.
+	if code, _ := healthOf(t, srv.handleLiveness); code != http.StatusOK {
+		t.Errorf("liveness = %d", code)
+	}
+}
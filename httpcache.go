@@ -0,0 +1,136 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bootID distinguishes generations of different runs, so an ETag from
+// before a restart never matches.
+var bootID = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// pollETag is the ETag of everything derived from a poll. It is weak
+// because the bytes differ with format and encoding.
+func pollETag(gen uint64) string {
+	return fmt.Sprintf(`W/"%s-%d"`, bootID, gen)
+}
+
+// writeCacheHeaders sets the validators and lifetime of a response
+// derived from snap, and replies 304 if the client's copy is current.
+// It returns false if the response has been written.
+func (s *server) writeCacheHeaders(w http.ResponseWriter, r *http.Request, snap snapshot) bool {
+	if snap.Generation == 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return true
+	}
+	// Long-polling clients pass this back as ?since=.
+	w.Header().Set("X-Poll-Generation", strconv.FormatUint(snap.Generation, 10))
+	etag := pollETag(snap.Generation)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", snap.Time.UTC().Format(http.TimeFormat))
+
+	// The data cannot change before the next poll is due.
+	maxAge := 0
+	if s.pollInterval > 0 {
+		maxAge = int(time.Until(snap.Time.Add(s.pollInterval)) / time.Second)
+	}
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(max(maxAge, 0)))
+
+	if notModified(r, etag, snap.Time) {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+	return true
+}
+
+// notModified evaluates If-None-Match or, if absent, If-Modified-Since
+// (RFC 9110, section 13.2.2).
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !modTime.Truncate(time.Second).After(ims)
+	}
+	return false
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// gzipHandler compresses responses for clients that accept gzip.
+func gzipHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			h(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		h(gw, r)
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the body unless the response has none.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status != http.StatusNotModified && status != http.StatusNoContent {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.gz.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+	}
+}
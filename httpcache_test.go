@@ -0,0 +1,111 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSensors_ConditionalRequests(t *testing.T) {
+	srv, mux := newAPIServer(apiSensors)
+	srv.pollInterval = time.Minute
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/sensors", nil))
+	etag, lastMod := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if etag != pollETag(1) || lastMod == "" {
+		t.Fatalf("ETag = %q, Last-Modified = %q", etag, lastMod)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "max-age=59" && cc != "max-age=60" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+
+	cases := []struct {
+		name, header, value string
+		want                int
+	}{
+		{"matching etag", "If-None-Match", etag, http.StatusNotModified},
+		{"etag list", "If-None-Match", `"other", ` + etag, http.StatusNotModified},
+		{"stale etag", "If-None-Match", pollETag(0), http.StatusOK},
+		{"same time", "If-Modified-Since", lastMod, http.StatusNotModified},
+		{"older time", "If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, c := range cases {
+		for _, target := range []string{"/sensors?format=text", "/sensors/heating_flow"} {
+			req := httptest.NewRequest("GET", target, nil)
+			req.Header.Set(c.header, c.value)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != c.want {
+				t.Errorf("%s %s: status = %d, want %d", c.name, target, rec.Code, c.want)
+			}
+			if rec.Code == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("%s %s: 304 with body", c.name, target)
+			}
+		}
+	}
+
+	// A new poll invalidates the old ETag.
+	srv.store(apiSensors)
+	req := httptest.NewRequest("GET", "/sensors", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != pollETag(2) {
+		t.Errorf("after poll: status = %d, ETag = %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestSensors_NoDataNotCached(t *testing.T) {
+	srv := &server{}
+	rec := httptest.NewRecorder()
+	srv.handleSensors(rec, httptest.NewRequest("GET", "/sensors", nil))
+	if rec.Header().Get("ETag") != "" || rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("headers before first poll = %v", rec.Header())
+	}
+}
+
+func TestGzipHandler(t *testing.T) {
+	srv, _ := newAPIServer(apiSensors)
+	h := gzipHandler(srv.handleSensors)
+
+	req := httptest.NewRequest("GET", "/sensors?format=text", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" || !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+		t.Fatalf("headers = %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if !strings.HasPrefix(string(body), "hot_water_middle 48.750\n") {
+		t.Errorf("body = %q", body)
+	}
+
+	for _, ae := range []string{"", "gzip;q=0", "identity"} {
+		req := httptest.NewRequest("GET", "/sensors", nil)
+		req.Header.Set("Accept-Encoding", ae)
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Header().Get("Content-Encoding") != "" || !strings.HasPrefix(rec.Body.String(), `{"sensors"`) {
+			t.Errorf("Accept-Encoding %q: compressed", ae)
+		}
+	}
+
+	// 304s have no body to compress.
+	req = httptest.NewRequest("GET", "/sensors", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", pollETag(1))
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+		t.Errorf("304: status = %d, headers = %v, %d bytes", rec.Code, rec.Header(), rec.Body.Len())
+	}
+}
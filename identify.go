@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultIdentifyDuration = time.Minute
+	defaultIdentifyInterval = time.Second
+	maxIdentifyDuration     = 5 * time.Minute
+	// A DS18B20 conversion takes up to 750ms, so sampling faster only
+	// keeps the bus busy.
+	minIdentifyInterval = 100 * time.Millisecond
+
+	// A probe only counts as identified if it warmed by at least
+	// minIdentifyRise and by twice as much as any other probe.
+	minIdentifyRise = 0.5
+)
+
+// identifyProbe is how one DS18B20 developed while identify sampled
+// the bus. Temperatures are in °C.
+type identifyProbe struct {
+	Address string  `json:"address"`
+	ID      string  `json:"id"`
+	Mapped  bool    `json:"mapped"`
+	Start   float64 `json:"start"`
+	Max     float64 `json:"max"`
+	Rise    float64 `json:"rise"`
+	Samples int     `json:"samples"`
+}
+
+type identifyResult struct {
+	Duration  string          `json:"duration"`
+	Probes    []identifyProbe `json:"probes"` // by rise, largest first
+	Candidate *identifyProbe  `json:"candidate"`
+	// SensorMap is SENSOR_MAP with the candidate assigned the
+	// requested ID, if one was given.
+	SensorMap string `json:"sensor_map,omitempty"`
+}
+
+// identify samples every DS18B20 on the bus each interval for the
+// given duration, or until ctx is done, and reports which one warmed
+// the most: the one being held in a hand. Probes are sampled
+// regardless of whether SENSOR_MAP names them. Failed reads are
+// skipped. Each round of samples holds bus, so polls are not read at
+// the same time but keep running in between.
+func identify(ctx context.Context, w1Path string, sensorMap map[string]string, duration, interval time.Duration, bus sync.Locker) identifyResult {
+	found := w1Probes(w1Path, sensorMap)
+	probes := make([]identifyProbe, len(found))
+	for i, p := range found {
+		probes[i] = identifyProbe{Address: p.Address, ID: p.ID, Mapped: p.Mapped}
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		bus.Lock()
+		for i, f := range found {
+			millideg, err := readW1Slave(f.Dir)
+			if err != nil {
+				continue
+			}
+			t := float64(millideg) / 1000
+			p := &probes[i]
+			if p.Samples == 0 {
+				p.Start, p.Max = t, t
+			}
+			p.Max = math.Max(p.Max, t)
+			p.Samples++
+		}
+		bus.Unlock()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		// Both may be ready after waiting for the bus; the deadline wins.
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	for i := range probes {
+		probes[i].Rise = math.Round((probes[i].Max-probes[i].Start)*1000) / 1000
+	}
+	sort.SliceStable(probes, func(i, j int) bool { return probes[i].Rise > probes[j].Rise })
+
+	result := identifyResult{Duration: time.Since(start).Round(time.Second).String(), Probes: probes}
+	if len(probes) > 0 && probes[0].Rise >= minIdentifyRise &&
+		(len(probes) == 1 || probes[0].Rise >= 2*probes[1].Rise) {
+		result.Candidate = &probes[0]
+	}
+	return result
+}
+
+// assignSensorID returns SENSOR_MAP with addr mapped to id. The
+// previous mapping of id, if any, is dropped, so the ID moves over to
+// the new probe.
+func assignSensorID(sensorMap map[string]string, addr, id string) string {
+	m := map[string]string{addr: id}
+	for a, i := range sensorMap {
+		if a != addr && i != id {
+			m[a] = i
+		}
+	}
+	return strings.Join(sensorMapEntries(m), ",")
+}
+
+// handleIdentify runs identify for ?duration= (default 1m) sampling
+// every ?interval= (default 1s) and returns the result. With ?id= the
+// response includes a SENSOR_MAP assigning that ID to the warmed probe.
+// Only one identification runs at a time.
+func (s *server) handleIdentify(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	duration, interval := defaultIdentifyDuration, defaultIdentifyInterval
+	if v := query.Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxIdentifyDuration {
+			writeJSONError(w, http.StatusBadRequest, "invalid duration %q (a duration up to %s)", v, maxIdentifyDuration)
+			return
+		}
+		duration = d
+	}
+	if v := query.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minIdentifyInterval {
+			writeJSONError(w, http.StatusBadRequest, "invalid interval %q (at least %s)", v, minIdentifyInterval)
+			return
+		}
+		interval = d
+	}
+
+	if !s.identifying.TryLock() {
+		writeJSONError(w, http.StatusConflict, "identification already running")
+		return
+	}
+	defer s.identifying.Unlock()
+
+	log.Printf("identify: sampling the bus for %s, requested by %s", duration, r.RemoteAddr)
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(duration + 10*time.Second))
+	result := identify(r.Context(), s.w1Path, s.sensorMap, duration, interval, &s.busMu)
+	if result.Candidate != nil {
+		log.Printf("identify: %s warmed by %.3f°C", result.Candidate.Address, result.Candidate.Rise)
+		if id := query.Get("id"); id != "" {
+			result.SensorMap = assignSensorID(s.sensorMap, result.Candidate.Address, id)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(result)
+}
+
+// runIdentify implements "tempsensorserver identify": it samples the
+// bus, prints how each probe developed and offers to assign an ID to
+// the one that was warmed. It returns the exit status.
+func runIdentify(args []string) int {
+	fs := flag.NewFlagSet("identify", flag.ExitOnError)
+	duration := fs.Duration("duration", defaultIdentifyDuration, "how long to sample")
+	interval := fs.Duration("interval", defaultIdentifyInterval, "time between samples")
+	id := fs.String("id", "", "ID to assign to the warmed probe (asked for if omitted)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: tempsensorserver identify [flags]\n\n"+
+			"Hold the probe to identify in your hand while the bus is sampled.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *interval < minIdentifyInterval {
+		fmt.Fprintf(os.Stderr, "-interval must be at least %s\n", minIdentifyInterval)
+		return 2
+	}
+
+	w1Path := envOrDefault("W1_PATH", defaultW1Path)
+	sensorMap := ParseSensorMap(os.Getenv("SENSOR_MAP"))
+	n := len(w1Devices(w1Path))
+	if n == 0 {
+		fmt.Fprintf(os.Stderr, "no DS18B20s found in %s\n", w1Path)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Sampling %d probes for %s (Ctrl-C to stop early).\n"+
+		"Hold the probe you want to identify in your hand now.\n\n", n, *duration)
+	result := identify(ctx, w1Path, sensorMap, *duration, *interval, new(sync.Mutex))
+	stop()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDRESS\tID\tSTART\tMAX\tRISE\tSAMPLES")
+	for _, p := range result.Probes {
+		fmt.Fprintf(tw, "%s\t%s\t%.3f\t%.3f\t%+.3f\t%d\n", p.Address, p.ID, p.Start, p.Max, p.Rise, p.Samples)
+	}
+	tw.Flush()
+	fmt.Println()
+
+	c := result.Candidate
+	if c == nil {
+		fmt.Printf("No probe warmed clearly more than the others (by %.1f°C and twice the runner-up).\n"+
+			"Hold it longer or sample for longer and try again.\n", minIdentifyRise)
+		return 1
+	}
+	fmt.Printf("You are holding %s (currently ID %q), it warmed by %.3f°C.\n", c.Address, c.ID, c.Rise)
+
+	if *id == "" && isTerminal(os.Stdin) {
+		fmt.Print("ID to assign (empty to skip): ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		*id = strings.TrimSpace(line)
+	}
+	if *id == "" {
+		return 0
+	}
+	fmt.Printf("\nSet this in the service environment and restart it:\n\nSENSOR_MAP=%s\n",
+		assignSensorID(sensorMap, c.Address, *id))
+	return 0
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeProbe atomically replaces a probe's w1_slave with a reading of
+// millideg, so concurrent reads never see a partial file.
+func writeProbe(t *testing.T, bus, addr string, millideg int) {
+	t.Helper()
+	dir := filepath.Join(bus, addr)
+	os.MkdirAll(dir, 0755)
+	content := fmt.Sprintf("33 00 4b 46 ff ff 02 10 f4 : crc=f4 YES\n33 00 4b 46 ff ff 02 10 f4 t=%d\n", millideg)
+	tmp := filepath.Join(dir, "w1_slave.tmp")
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "w1_slave")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tankBus(t *testing.T) string {
+	bus := t.TempDir()
+	for i := 1; i <= 4; i++ {
+		writeProbe(t, bus, fmt.Sprintf("28-00000000000%d", i), 45000)
+	}
+	return bus
+}
+
+func TestIdentify(t *testing.T) {
+	bus := tankBus(t)
+	sensorMap := map[string]string{"28-000000000001": "hot_water_top", "28-000000000003": "hot_water_bottom"}
+
+	// Someone grabs probe 3 and it warms by 1.5°C; probe 1 drifts.
+	time.AfterFunc(30*time.Millisecond, func() {
+		writeProbe(t, bus, "28-000000000003", 46500)
+		writeProbe(t, bus, "28-000000000001", 45125)
+	})
+	result := identify(context.Background(), bus, sensorMap, 150*time.Millisecond, 10*time.Millisecond, new(sync.Mutex))
+
+	c := result.Candidate
+	if c == nil {
+		t.Fatalf("no candidate: %+v", result.Probes)
+	}
+	if c.Address != "28-000000000003" || c.ID != "hot_water_bottom" || !c.Mapped || c.Start != 45 || c.Rise != 1.5 || c.Samples < 3 {
+		t.Errorf("candidate = %+v", c)
+	}
+	if len(result.Probes) != 4 || result.Probes[1].Address != "28-000000000001" || result.Probes[1].Rise != 0.125 {
+		t.Errorf("probes = %+v", result.Probes)
+	}
+	if result.Probes[2].ID != "1" || result.Probes[2].Mapped {
+		t.Errorf("unmapped probe = %+v", result.Probes[2])
+	}
+}
+
+func TestIdentify_Ambiguous(t *testing.T) {
+	bus := tankBus(t)
+	time.AfterFunc(30*time.Millisecond, func() {
+		writeProbe(t, bus, "28-000000000002", 46000)
+		writeProbe(t, bus, "28-000000000004", 45750)
+	})
+	result := identify(context.Background(), bus, nil, 100*time.Millisecond, 10*time.Millisecond, new(sync.Mutex))
+	if result.Candidate != nil {
+		t.Errorf("candidate = %+v, want none when two probes warm", result.Candidate)
+	}
+
+	// Nothing warmed at all.
+	result = identify(context.Background(), tankBus(t), nil, 30*time.Millisecond, 10*time.Millisecond, new(sync.Mutex))
+	if result.Candidate != nil {
+		t.Errorf("candidate = %+v, want none", result.Candidate)
+	}
+}
+
+func TestIdentify_WaitsForPoll(t *testing.T) {
+	// A poll holding the bus for longer than the identification leaves
+	// room for one round of samples only, taken after it.
+	var bus sync.Mutex
+	bus.Lock()
+	time.AfterFunc(100*time.Millisecond, bus.Unlock)
+	result := identify(context.Background(), tankBus(t), nil, 50*time.Millisecond, 10*time.Millisecond, &bus)
+	for _, p := range result.Probes {
+		if p.Samples != 1 {
+			t.Errorf("%s: %d samples, want 1", p.Address, p.Samples)
+		}
+	}
+}
+
+func TestAssignSensorID(t *testing.T) {
+	sensorMap := map[string]string{"28-000000000001": "hot_water_top", "28-000000000002": "heating_supply"}
+	// The ID moves from probe 1 to probe 3.
+	got := assignSensorID(sensorMap, "28-000000000003", "hot_water_top")
+	if want := "28-000000000002:heating_supply,28-000000000003:hot_water_top"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got = assignSensorID(sensorMap, "28-000000000002", "heating_return")
+	if want := "28-000000000001:hot_water_top,28-000000000002:heating_return"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHandleIdentify(t *testing.T) {
+	bus := tankBus(t)
+	srv := &server{w1Path: bus, pollToken: "s3cret", sensorMap: map[string]string{"28-000000000001": "hot_water_top"}}
+	post := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/identify"+query, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		srv.requireToken(srv.handleIdentify)(rec, req)
+		return rec
+	}
+
+	for _, q := range []string{"?duration=1h", "?interval=1ns"} {
+		if rec := post(q); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d", q, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	srv.requireToken(srv.handleIdentify)(rec, httptest.NewRequest("POST", "/identify", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: %d", rec.Code)
+	}
+
+	srv.identifying.Lock()
+	if rec := post("?duration=10ms"); rec.Code != http.StatusConflict {
+		t.Errorf("concurrent: %d", rec.Code)
+	}
+	srv.identifying.Unlock()
+
+	time.AfterFunc(30*time.Millisecond, func() { writeProbe(t, bus, "28-000000000004", 47000) })
+	rec = post("?duration=300ms&interval=100ms&id=hot_water_top")
+	var result identifyResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("%d: %v", rec.Code, err)
+	}
+	if result.Candidate == nil || result.Candidate.Address != "28-000000000004" {
+		t.Errorf("candidate = %+v", result.Candidate)
+	}
+	if result.SensorMap != "28-000000000004:hot_water_top" {
+		t.Errorf("sensor_map = %q", result.SensorMap)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultInfluxBatchSize = 1000
+	defaultInfluxMaxSpool  = 10 << 20 // bytes
+	influxAttempts         = 3
+)
+
+// influxWriter writes each poll to InfluxDB as line protocol, either
+// via the v1 /write endpoint or the v2 /api/v2/write endpoint.
+//
+// Lines that cannot be delivered because InfluxDB is unreachable are
+// appended to an optional spool file and replayed, oldest first,
+// before new lines on the next successful push.
+type influxWriter struct {
+	writeURL   string
+	authHeader string
+	username   string
+	password   string
+	client     *http.Client
+	gzip       bool
+	batchSize  int
+	retryDelay time.Duration
+	spoolPath  string
+	maxSpool   int64
+
+	failures int
+}
+
+func newInfluxWriter(writeURL string) *influxWriter {
+	return &influxWriter{
+		writeURL:   writeURL,
+		gzip:       true,
+		batchSize:  defaultInfluxBatchSize,
+		retryDelay: time.Second,
+		maxSpool:   defaultInfluxMaxSpool,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// NewInfluxWriterV1 writes to database db using the InfluxDB 1.x API.
+func NewInfluxWriterV1(baseURL, db string) *influxWriter {
+	q := url.Values{"db": {db}, "precision": {"ms"}}
+	return newInfluxWriter(strings.TrimRight(baseURL, "/") + "/write?" + q.Encode())
+}
+
+// NewInfluxWriterV2 writes to bucket in org using the InfluxDB 2.x API.
+func NewInfluxWriterV2(baseURL, org, bucket, token string) *influxWriter {
+	q := url.Values{"org": {org}, "bucket": {bucket}, "precision": {"ms"}}
+	w := newInfluxWriter(strings.TrimRight(baseURL, "/") + "/api/v2/write?" + q.Encode())
+	w.authHeader = "Token " + token
+	return w
+}
+
+func (w *influxWriter) Name() string { return "influxdb" }
+
+func (w *influxWriter) Push(sensors []Sensor) error {
+	lines := influxLines(sensors, time.Now())
+	spooled, err := w.readSpool()
+	if err != nil {
+		log.Printf("influx: reading spool: %v", err)
+	}
+	pending := append(spooled, lines...)
+
+	written := 0
+	var lastErr error
+	for len(pending) > 0 {
+		n := min(len(pending), w.batchSize)
+		err := withRetry(influxAttempts, w.retryDelay, func() error {
+			return w.write(pending[:n])
+		})
+		if err != nil {
+			lastErr = err
+			w.failures++
+			if w.failures == 1 || w.failures%10 == 0 {
+				log.Printf("influx: write failed (%d consecutive): %v", w.failures, err)
+			}
+			if !isRetryable(err) {
+				log.Printf("influx: dropping %d lines rejected by server", n)
+				pending = pending[n:]
+				continue
+			}
+			break
+		}
+		written += n
+		pending = pending[n:]
+	}
+
+	// The spool is only rewritten once some of it has been replayed;
+	// otherwise what is left of the new lines is appended.
+	switch {
+	case len(pending) == len(spooled)+len(lines):
+		err = w.appendSpool(lines)
+	case len(spooled) == 0:
+		err = w.appendSpool(pending)
+	default:
+		err = w.writeSpool(pending)
+	}
+	if err != nil {
+		log.Printf("influx: writing spool: %v", err)
+	}
+	if written > 0 && w.failures > 0 {
+		log.Printf("influx: recovered after %d failures", w.failures)
+		w.failures = 0
+	}
+	if len(spooled) > 0 && len(pending) == 0 {
+		log.Printf("influx: replayed %d spooled lines", len(spooled))
+	}
+	return lastErr
+}
+
+func (w *influxWriter) write(lines []string) error {
+	var body bytes.Buffer
+	if w.gzip {
+		zw := gzip.NewWriter(&body)
+		for _, l := range lines {
+			io.WriteString(zw, l+"\n")
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+	} else {
+		for _, l := range lines {
+			body.WriteString(l + "\n")
+		}
+	}
+
+	req, err := http.NewRequest("POST", w.writeURL, &body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	switch {
+	case w.authHeader != "":
+		req.Header.Set("Authorization", w.authHeader)
+	case w.username != "":
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return retryable(fmt.Errorf("do request: %w", err))
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		err := fmt.Errorf("unexpected status %d", resp.StatusCode)
+		if retryableStatus(resp.StatusCode) {
+			return retryable(err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (w *influxWriter) readSpool() ([]string, error) {
+	if w.spoolPath == "" {
+		return nil, nil
+	}
+	f, err := os.Open(w.spoolPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, sc.Err()
+}
+
+// appendSpool adds lines to the end of the spool. The spool is only
+// rewritten, without its oldest lines, once it exceeds maxSpool bytes.
+// Without a spool path, undelivered lines are discarded.
+func (w *influxWriter) appendSpool(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	if w.spoolPath == "" {
+		log.Printf("influx: discarding %d undelivered lines (no spool)", len(lines))
+		return nil
+	}
+	f, err := os.OpenFile(w.spoolPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, strings.Join(lines, "\n")+"\n")
+	var size int64
+	if fi, serr := f.Stat(); serr == nil {
+		size = fi.Size()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || size <= w.maxSpool {
+		return err
+	}
+
+	spooled, err := w.readSpool()
+	if err != nil {
+		return err
+	}
+	return w.writeSpool(spooled)
+}
+
+// writeSpool replaces the spool with lines, dropping the oldest lines
+// if they exceed maxSpool bytes.
+func (w *influxWriter) writeSpool(lines []string) error {
+	if len(lines) == 0 {
+		err := os.Remove(w.spoolPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var size int64
+	start := len(lines)
+	for start > 0 && size+int64(len(lines[start-1])+1) <= w.maxSpool {
+		start--
+		size += int64(len(lines[start]) + 1)
+	}
+	if start > 0 {
+		log.Printf("influx: spool full, dropping %d oldest lines", start)
+	}
+
+	tmp := w.spoolPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines[start:], "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.spoolPath)
+}
+
+// influxLines renders sensors as line protocol with millisecond
+// timestamps, e.g.
+//
+//	temperature,address=28-02131ad2cdaa,location=hot_water_tank,sensor=hot_water_middle value=48.75 1700000000000
+func influxLines(sensors []Sensor, now time.Time) []string {
+	ts := strconv.FormatInt(now.UnixMilli(), 10)
+
+	var lines []string
+	for _, s := range sensors {
+		val, err := strconv.ParseFloat(s.Value, 64)
+		if err != nil {
+			log.Printf("influx: skipping %s: invalid value %q", s.ID, s.Value)
+			continue
+		}
+
+		meta := sensorMetaMap[s.ID]
+		measurement := meta.DeviceClass
+		if measurement == "" {
+			measurement = "sensor"
+		}
+
+		var b strings.Builder
+		b.WriteString(influxEscape(measurement, ", "))
+		if s.Address != "" {
+			b.WriteString(",address=" + influxEscape(s.Address, ",= "))
+		}
+		if meta.Location != "" {
+			b.WriteString(",location=" + influxEscape(meta.Location, ",= "))
+		}
+		b.WriteString(",sensor=" + influxEscape(s.ID, ",= "))
+		b.WriteString(" value=" + strconv.FormatFloat(val, 'f', -1, 64))
+		b.WriteString(" " + ts)
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+func influxEscape(s, special string) string {
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
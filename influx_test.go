@@ -0,0 +1,252 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type influxReceiver struct {
+	mu      sync.Mutex
+	bodies  []string
+	queries []string
+	paths   []string
+	auth    []string
+	status  int
+}
+
+func newInfluxReceiver(t *testing.T) (*httptest.Server, *influxReceiver) {
+	rec := &influxReceiver{status: http.StatusNoContent}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("gzip reader: %v", err)
+				return
+			}
+			body = zr
+		}
+		data, _ := io.ReadAll(body)
+
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if rec.status/100 == 2 {
+			rec.bodies = append(rec.bodies, string(data))
+			rec.queries = append(rec.queries, r.URL.RawQuery)
+			rec.paths = append(rec.paths, r.URL.Path)
+			rec.auth = append(rec.auth, r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(rec.status)
+	}))
+	return ts, rec
+}
+
+func (rec *influxReceiver) setStatus(code int) {
+	rec.mu.Lock()
+	rec.status = code
+	rec.mu.Unlock()
+}
+
+func TestInfluxLines(t *testing.T) {
+	now := time.UnixMilli(1700000000123)
+	lines := influxLines([]Sensor{
+		{ID: "hot_water_middle", Value: "48.750", Address: "28-000000000001"},
+		{ID: "utility_room_humidity", Value: "49.3", Address: "iio:device0"},
+		{ID: "odd id,x=y", Value: "1"},
+		{ID: "broken", Value: "n/a"},
+	}, now)
+
+	want := []string{
+		`temperature,address=28-000000000001,location=hot_water_tank,sensor=hot_water_middle value=48.75 1700000000123`,
+		`humidity,address=iio:device0,location=utility_room,sensor=utility_room_humidity value=49.3 1700000000123`,
+		`sensor,sensor=odd\ id\,x\=y value=1 1700000000123`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %q, want %d lines", lines, len(want))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestInfluxWriterV2(t *testing.T) {
+	ts, rec := newInfluxReceiver(t)
+	defer ts.Close()
+
+	w := NewInfluxWriterV2(ts.URL, "home", "heating", "tok")
+	w.Push([]Sensor{{ID: "heating_supply", Value: "42.500", Address: "28-000000000002"}})
+
+	if len(rec.bodies) != 1 {
+		t.Fatalf("requests = %d, want 1", len(rec.bodies))
+	}
+	if rec.paths[0] != "/api/v2/write" {
+		t.Errorf("path = %q, want /api/v2/write", rec.paths[0])
+	}
+	if rec.queries[0] != "bucket=heating&org=home&precision=ms" {
+		t.Errorf("query = %q", rec.queries[0])
+	}
+	if rec.auth[0] != "Token tok" {
+		t.Errorf("auth = %q, want Token tok", rec.auth[0])
+	}
+	if !strings.HasPrefix(rec.bodies[0], "temperature,address=28-000000000002,location=heating,sensor=heating_supply value=42.5 ") {
+		t.Errorf("body = %q", rec.bodies[0])
+	}
+}
+
+func TestInfluxWriterV1(t *testing.T) {
+	ts, rec := newInfluxReceiver(t)
+	defer ts.Close()
+
+	w := NewInfluxWriterV1(ts.URL, "tempsensor")
+	w.username = "user"
+	w.password = "pass"
+	w.gzip = false
+	w.Push([]Sensor{{ID: "0", Value: "21.000"}})
+
+	if len(rec.bodies) != 1 {
+		t.Fatalf("requests = %d, want 1", len(rec.bodies))
+	}
+	if rec.paths[0] != "/write" || rec.queries[0] != "db=tempsensor&precision=ms" {
+		t.Errorf("url = %s?%s", rec.paths[0], rec.queries[0])
+	}
+	if !strings.HasPrefix(rec.auth[0], "Basic ") {
+		t.Errorf("auth = %q, want basic auth", rec.auth[0])
+	}
+}
+
+func TestInfluxWriter_Batching(t *testing.T) {
+	ts, rec := newInfluxReceiver(t)
+	defer ts.Close()
+
+	w := NewInfluxWriterV1(ts.URL, "db")
+	w.batchSize = 2
+	w.Push([]Sensor{{ID: "0", Value: "1"}, {ID: "1", Value: "2"}, {ID: "2", Value: "3"}})
+
+	if len(rec.bodies) != 2 {
+		t.Fatalf("requests = %d, want 2", len(rec.bodies))
+	}
+	if n := strings.Count(rec.bodies[0], "\n"); n != 2 {
+		t.Errorf("first batch lines = %d, want 2", n)
+	}
+}
+
+func TestInfluxWriter_SpoolAndReplay(t *testing.T) {
+	ts, rec := newInfluxReceiver(t)
+	defer ts.Close()
+	rec.setStatus(http.StatusServiceUnavailable)
+
+	w := NewInfluxWriterV1(ts.URL, "db")
+	w.retryDelay = time.Millisecond
+	w.spoolPath = filepath.Join(t.TempDir(), "influx.spool")
+
+	w.Push([]Sensor{{ID: "0", Value: "1"}})
+	w.Push([]Sensor{{ID: "0", Value: "2"}})
+
+	if w.failures != 2 {
+		t.Errorf("failures = %d, want 2", w.failures)
+	}
+	spooled, _ := w.readSpool()
+	if len(spooled) != 2 {
+		t.Fatalf("spooled = %d lines, want 2", len(spooled))
+	}
+
+	rec.setStatus(http.StatusNoContent)
+	w.Push([]Sensor{{ID: "0", Value: "3"}})
+
+	if len(rec.bodies) != 1 {
+		t.Fatalf("requests = %d, want 1", len(rec.bodies))
+	}
+	lines := strings.Split(strings.TrimSpace(rec.bodies[0]), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("lines = %q, want 3", lines)
+	}
+	for i, v := range []string{"value=1 ", "value=2 ", "value=3 "} {
+		if !strings.Contains(lines[i], v) {
+			t.Errorf("line %d = %q, want %s (oldest first)", i, lines[i], v)
+		}
+	}
+	if _, err := os.Stat(w.spoolPath); !os.IsNotExist(err) {
+		t.Errorf("spool should be removed after replay, stat err = %v", err)
+	}
+	if w.failures != 0 {
+		t.Errorf("failures = %d, want 0", w.failures)
+	}
+}
+
+func TestInfluxWriter_SpoolLimit(t *testing.T) {
+	w := NewInfluxWriterV1("http://192.0.2.1:1", "db")
+	w.client.Timeout = 50 * time.Millisecond
+	w.retryDelay = time.Millisecond
+	w.spoolPath = filepath.Join(t.TempDir(), "influx.spool")
+
+	lines := []string{"a value=1 1", "b value=2 2", "c value=3 3"}
+	w.maxSpool = int64(len(lines[1]) + len(lines[2]) + 2)
+	if err := w.writeSpool(lines); err != nil {
+		t.Fatalf("writeSpool: %v", err)
+	}
+
+	got, _ := w.readSpool()
+	if len(got) != 2 || got[0] != lines[1] || got[1] != lines[2] {
+		t.Errorf("spool = %q, want newest two lines", got)
+	}
+}
+
+func TestInfluxWriter_SpoolAppends(t *testing.T) {
+	ts, rec := newInfluxReceiver(t)
+	defer ts.Close()
+	rec.setStatus(http.StatusServiceUnavailable)
+
+	w := NewInfluxWriterV1(ts.URL, "db")
+	w.retryDelay = time.Millisecond
+	w.spoolPath = filepath.Join(t.TempDir(), "influx.spool")
+
+	w.Push([]Sensor{{ID: "0", Value: "1"}})
+	before, err := os.Stat(w.spoolPath)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	w.Push([]Sensor{{ID: "0", Value: "2"}})
+	after, err := os.Stat(w.spoolPath)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if !os.SameFile(before, after) || after.Size() != 2*before.Size() {
+		t.Errorf("spool rewritten instead of appended to: %d -> %d bytes", before.Size(), after.Size())
+	}
+
+	// Over maxSpool the oldest lines go.
+	w.maxSpool = after.Size()
+	w.Push([]Sensor{{ID: "0", Value: "3"}})
+	spooled, _ := w.readSpool()
+	if len(spooled) != 2 || !strings.Contains(spooled[0], "value=2 ") || !strings.Contains(spooled[1], "value=3 ") {
+		t.Errorf("spool = %q, want the newest two lines", spooled)
+	}
+}
+
+func TestInfluxWriter_DropOn4xx(t *testing.T) {
+	ts, rec := newInfluxReceiver(t)
+	defer ts.Close()
+	rec.setStatus(http.StatusBadRequest)
+
+	w := NewInfluxWriterV1(ts.URL, "db")
+	w.retryDelay = time.Millisecond
+	w.spoolPath = filepath.Join(t.TempDir(), "influx.spool")
+	w.Push([]Sensor{{ID: "0", Value: "1"}})
+
+	if spooled, _ := w.readSpool(); len(spooled) != 0 {
+		t.Errorf("spooled = %q, want nothing for rejected lines", spooled)
+	}
+	if w.failures != 1 {
+		t.Errorf("failures = %d, want 1", w.failures)
+	}
+}
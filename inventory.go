@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// inventoryDevice is a 1-Wire device known to the inventory.
+type inventoryDevice struct {
+	Address string `json:"address"`
+	ID      string `json:"id"`
+	Model   string `json:"model,omitempty"`
+	Value   string `json:"value,omitempty"` // latest reading, if any
+}
+
+// inventory treats SENSOR_MAP as the list of devices that should be
+// on the 1-Wire bus. After every poll it compares the bus against it
+// and logs devices that go missing or show up unannounced, once per
+// transition.
+type inventory struct {
+	mu         sync.Mutex
+	missing    map[string]inventoryDevice // by address
+	unexpected map[string]inventoryDevice
+	unmapped   []inventoryDevice
+}
+
+func newInventory() *inventory {
+	return &inventory{
+		missing:    make(map[string]inventoryDevice),
+		unexpected: make(map[string]inventoryDevice),
+	}
+}
+
+// Update compares the probes found on the bus with sensorMap. sensors
+// supplies the current readings for the report.
+func (inv *inventory) Update(sensorMap map[string]string, probes []w1Probe, sensors []Sensor) {
+	values := make(map[string]Sensor)
+	for _, s := range sensors {
+		if s.Address != "" {
+			values[s.Address] = s
+		}
+	}
+
+	present := make(map[string]bool)
+	var unmapped []inventoryDevice
+	for _, p := range probes {
+		present[p.Address] = true
+		if !p.Mapped {
+			unmapped = append(unmapped, inventoryDevice{Address: p.Address, ID: p.ID, Model: w1Model(p.Address), Value: values[p.Address].Value})
+		}
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.unmapped = unmapped
+
+	for addr, id := range sensorMap {
+		_, wasMissing := inv.missing[addr]
+		switch {
+		case !present[addr] && !wasMissing:
+			log.Printf("inventory: expected sensor %s (%s) is missing from the bus", id, addr)
+			inv.missing[addr] = inventoryDevice{Address: addr, ID: id, Model: w1Model(addr)}
+		case present[addr] && wasMissing:
+			log.Printf("inventory: expected sensor %s (%s) is back", id, addr)
+			delete(inv.missing, addr)
+		}
+	}
+	for addr := range inv.missing {
+		if _, ok := sensorMap[addr]; !ok {
+			delete(inv.missing, addr)
+		}
+	}
+
+	// Without a SENSOR_MAP there is no inventory to deviate from.
+	if len(sensorMap) == 0 {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, d := range unmapped {
+		seen[d.Address] = true
+		if _, ok := inv.unexpected[d.Address]; !ok {
+			log.Printf("inventory: unexpected %s %s on the bus (not in SENSOR_MAP)", d.Model, d.Address)
+		}
+		inv.unexpected[d.Address] = d
+	}
+	for addr := range inv.unexpected {
+		if !seen[addr] {
+			log.Printf("inventory: unexpected device %s left the bus", addr)
+			delete(inv.unexpected, addr)
+		}
+	}
+}
+
+// Missing returns the expected devices not found on the bus.
+func (inv *inventory) Missing() []inventoryDevice {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return sortedDevices(inv.missing)
+}
+
+// Unexpected returns the devices on the bus that are not in SENSOR_MAP.
+// It is empty when no SENSOR_MAP is configured.
+func (inv *inventory) Unexpected() []inventoryDevice {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return sortedDevices(inv.unexpected)
+}
+
+// Unmapped returns the devices on the bus that are not in SENSOR_MAP,
+// whether or not one is configured.
+func (inv *inventory) Unmapped() []inventoryDevice {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return append([]inventoryDevice{}, inv.unmapped...)
+}
+
+func sortedDevices(m map[string]inventoryDevice) []inventoryDevice {
+	devices := make([]inventoryDevice, 0, len(m))
+	for _, d := range m {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Address < devices[j].Address })
+	return devices
+}
+
+type inventoryResponse struct {
+	Missing  []inventoryDevice `json:"missing"`
+	Unmapped []inventoryDevice `json:"unmapped"`
+	// SensorMap is SENSOR_MAP with the unmapped devices appended under
+	// their current IDs, ready to be edited.
+	SensorMap string `json:"sensor_map"`
+}
+
+// handleInventory lists the devices found on the bus that SENSOR_MAP
+// does not name, along with the expected ones that are missing.
+func (s *server) handleInventory(w http.ResponseWriter, r *http.Request) {
+	unmapped := s.inventory.Unmapped()
+	entries := sensorMapEntries(s.sensorMap)
+	for _, d := range unmapped {
+		entries = append(entries, d.Address+":"+d.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inventoryResponse{
+		Missing:   s.inventory.Missing(),
+		Unmapped:  unmapped,
+		SensorMap: strings.Join(entries, ","),
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestInventory_Transitions(t *testing.T) {
+	logs := captureLog(t)
+	sensorMap := map[string]string{
+		"28-000000000001": "hot_water_middle",
+		"28-000000000002": "heating_supply",
+	}
+	bus := func(addrs ...string) []w1Probe {
+		dir := t.TempDir()
+		for _, a := range addrs {
+			os.Mkdir(filepath.Join(dir, a), 0755)
+		}
+		return w1Probes(dir, sensorMap)
+	}
+
+	inv := newInventory()
+	inv.Update(sensorMap, bus("28-000000000001", "28-000000000002"), nil)
+	if len(inv.Missing()) != 0 || len(inv.Unexpected()) != 0 || logs.Len() != 0 {
+		t.Fatalf("complete bus: missing %v, unexpected %v, logs %q", inv.Missing(), inv.Unexpected(), logs)
+	}
+
+	// A probe falls off and an unknown one appears: logged once each.
+	for i := 0; i < 3; i++ {
+		inv.Update(sensorMap, bus("28-000000000001", "28-0000000000ff"),
+			[]Sensor{{ID: "1", Value: "30.000", Address: "28-0000000000ff"}})
+	}
+	if m := inv.Missing(); len(m) != 1 || m[0].ID != "heating_supply" || m[0].Address != "28-000000000002" {
+		t.Errorf("missing = %+v", m)
+	}
+	if u := inv.Unexpected(); len(u) != 1 || u[0].Address != "28-0000000000ff" || u[0].Value != "30.000" || u[0].Model != "DS18B20" {
+		t.Errorf("unexpected = %+v", u)
+	}
+	if n := strings.Count(logs.String(), "\n"); n != 2 {
+		t.Errorf("logged %d lines, want 2:\n%s", n, logs)
+	}
+
+	logs.Reset()
+	inv.Update(sensorMap, bus("28-000000000001", "28-000000000002"), nil)
+	if len(inv.Missing()) != 0 || len(inv.Unexpected()) != 0 {
+		t.Errorf("restored: missing %v, unexpected %v", inv.Missing(), inv.Unexpected())
+	}
+	if !strings.Contains(logs.String(), "heating_supply (28-000000000002) is back") ||
+		!strings.Contains(logs.String(), "28-0000000000ff left the bus") {
+		t.Errorf("logs = %q", logs)
+	}
+}
+
+func TestInventory_NoSensorMap(t *testing.T) {
+	inv := newInventory()
+	inv.Update(nil, []w1Probe{{Address: "28-000000000001", ID: "0"}}, nil)
+	if len(inv.Unexpected()) != 0 || len(inv.Unmapped()) != 1 {
+		t.Errorf("unexpected = %v, unmapped = %v", inv.Unexpected(), inv.Unmapped())
+	}
+}
+
+func TestHealthAndInventory(t *testing.T) {
+	srv := &server{
+		w1Path:    "testdata/w1_bus_master1",
+		sensorMap: map[string]string{"28-000000000001": "hot_water_middle", "28-000000000009": "heating_return"},
+		inventory: newInventory(),
+	}
+	captureLog(t)
+	srv.poll()
+
+	rec := httptest.NewRecorder()
+	srv.handleHealth(rec, httptest.NewRequest("GET", "/health", nil))
+	var health healthResponse
+	json.NewDecoder(rec.Body).Decode(&health)
+	if health.Status != "degraded" || len(health.Missing) != 1 || health.Missing[0].ID != "heating_return" || len(health.Unexpected) != 3 {
+		t.Errorf("health = %+v", health)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.handleInventory(rec, httptest.NewRequest("GET", "/inventory", nil))
+	var inv inventoryResponse
+	json.NewDecoder(rec.Body).Decode(&inv)
+	if len(inv.Unmapped) != 3 || inv.Unmapped[0] != (inventoryDevice{Address: "28-000000000002", ID: "1", Model: "DS18B20", Value: "22.875"}) {
+		t.Errorf("unmapped = %+v", inv.Unmapped)
+	}
+	want := "28-000000000001:hot_water_middle,28-000000000009:heating_return," +
+		"28-000000000002:1,28-000000000003:2,28-000000000004:3"
+	if inv.SensorMap != want {
+		t.Errorf("sensor_map = %q, want %q", inv.SensorMap, want)
+	}
+
+	// Mapping everything clears the warnings.
+	srv.sensorMap = ParseSensorMap(inv.SensorMap)
+	delete(srv.sensorMap, "28-000000000009")
+	srv.poll()
+	rec = httptest.NewRecorder()
+	srv.handleHealth(rec, httptest.NewRequest("GET", "/health", nil))
+	health = healthResponse{}
+	json.NewDecoder(rec.Body).Decode(&health)
+	if health.Status != "ok" || len(health.Missing) != 0 || len(health.Unexpected) != 0 {
+		t.Errorf("health after mapping = %+v", health)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Legacy IDs of the DHT22 readings in the Java/Jetty server.
+const (
+	legacyDHT22Temperature = "100"
+	legacyDHT22Humidity    = "101"
+)
+
+// legacyID returns the ID the Java/Jetty server used for a sensor:
+// 1-Wire probes are numbered 0, 1, ... by bus position regardless of
+// SENSOR_MAP, and the DHT22 readings are 100 and 101. Sensors the old
+// server did not know have no legacy ID.
+func legacyID(s Sensor) (string, bool) {
+	switch {
+	case s.Driver == "w1" && s.Model == "DS18B20":
+		return strconv.Itoa(s.Index), true
+	case s.Driver == "iio" && sensorKind(s) == "temperature":
+		return legacyDHT22Temperature, true
+	case s.Driver == "iio" && sensorKind(s) == "humidity":
+		return legacyDHT22Humidity, true
+	}
+	return "", false
+}
+
+// legacySensors renames sensors to their legacy IDs, dropping those
+// without one.
+func legacySensors(sensors []Sensor) []Sensor {
+	legacy := []Sensor{}
+	for _, s := range sensors {
+		if id, ok := legacyID(s); ok {
+			legacy = append(legacy, Sensor{ID: id, Value: s.Value})
+		}
+	}
+	return legacy
+}
+
+// legacyMux serves the endpoints of the Java/Jetty server. The bodies
+// are byte-for-byte what the first Go release served in its place.
+func (s *server) legacyMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sensors", s.handleLegacySensors)
+	mux.HandleFunc("/health", s.handleLegacyHealth)
+	return mux
+}
+
+func (s *server) handleLegacySensors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Sensors []Sensor `json:"sensors"`
+	}{legacySensors(s.sensors())})
+}
+
+func (s *server) handleLegacyHealth(w http.ResponseWriter, r *http.Request) {
+	sensors := legacySensors(s.sensors())
+	status := "ok"
+	if len(sensors) == 0 {
+		status = "no_data"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"%s","sensors":%d}`, status, len(sensors))
+}
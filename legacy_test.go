@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newLegacyServer(sensorMap map[string]string) *server {
+	srv := &server{w1Path: "testdata/w1_bus_master1", iioPath: "testdata/iio_device", sensorMap: sensorMap}
+	srv.store(ReadAll(srv.w1Path, srv.iioPath, srv.sensorMap))
+	return srv
+}
+
+// The legacy responses must not change: consumers of the Java/Jetty
+// server parse them byte by byte.
+func TestLegacyAPI_ExactBytes(t *testing.T) {
+	// SENSOR_MAP names do not leak into the legacy IDs.
+	srv := newLegacyServer(map[string]string{
+		"28-000000000001": "hot_water_middle",
+		"28-000000000003": "hot_water_bottom",
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/legacy/", http.StripPrefix("/legacy", srv.legacyMux()))
+
+	cases := []struct {
+		path, body string
+	}{
+		{"/sensors", `{"sensors":[{"id":"0","value":"48.750"},{"id":"1","value":"22.875"},` +
+			`{"id":"2","value":"46.250"},{"id":"3","value":"21.437"},` +
+			`{"id":"100","value":"21.3"},{"id":"101","value":"49.3"}]}` + "\n"},
+		{"/health", `{"status":"ok","sensors":6}`},
+	}
+	for _, c := range cases {
+		for _, h := range []http.Handler{srv.legacyMux(), mux} {
+			target := c.path
+			if h == mux {
+				target = "/legacy" + c.path
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("%s: status = %d, content-type = %q", target, rec.Code, rec.Header().Get("Content-Type"))
+			}
+			if got := rec.Body.String(); got != c.body {
+				t.Errorf("%s:\n got %s\nwant %s", target, got, c.body)
+			}
+		}
+	}
+}
+
+func TestLegacyAPI_NoData(t *testing.T) {
+	srv := &server{}
+	rec := httptest.NewRecorder()
+	srv.legacyMux().ServeHTTP(rec, httptest.NewRequest("GET", "/sensors", nil))
+	if got := rec.Body.String(); got != `{"sensors":[]}`+"\n" {
+		t.Errorf("sensors = %s", got)
+	}
+	rec = httptest.NewRecorder()
+	srv.legacyMux().ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	if got := rec.Body.String(); got != `{"status":"no_data","sensors":0}` {
+		t.Errorf("health = %s", got)
+	}
+}
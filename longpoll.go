@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxLongPollWait = 5 * time.Minute
+
+// waitForPoll returns the latest poll result once its generation
+// differs from since: it is newer, or since is from before a restart.
+// When timeout elapses, ctx is done or the server shuts down it
+// returns whatever is current. All waiters share one channel per poll
+// and sleep until store closes it.
+func (s *server) waitForPoll(ctx context.Context, since uint64, timeout time.Duration) snapshot {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.pollMu.Lock()
+		snap := s.snapshot()
+		if snap.Generation != since {
+			s.pollMu.Unlock()
+			return snap
+		}
+		if s.polled == nil {
+			s.polled = make(chan struct{})
+		}
+		polled := s.polled
+		s.pollMu.Unlock()
+
+		select {
+		case <-polled:
+		case <-timer.C:
+			return s.snapshot()
+		case <-ctx.Done():
+			return s.snapshot()
+		case <-s.done:
+			return s.snapshot()
+		}
+	}
+}
+
+// requestSnapshot returns the poll result a /sensors request is
+// answered from. With ?wait=30s it blocks until a poll newer than
+// ?since=<generation> (default: the current one) completes. It
+// replies with an error and returns false if the parameters are
+// invalid.
+func (s *server) requestSnapshot(w http.ResponseWriter, r *http.Request) (snapshot, bool) {
+	query := r.URL.Query()
+	if !query.Has("wait") {
+		return s.snapshot(), true
+	}
+	wait, err := time.ParseDuration(query.Get("wait"))
+	if err != nil || wait < 0 || wait > maxLongPollWait {
+		writeJSONError(w, http.StatusBadRequest, "invalid wait %q (a duration up to %s)", query.Get("wait"), maxLongPollWait)
+		return snapshot{}, false
+	}
+	since := s.snapshot().Generation
+	if v := query.Get("since"); v != "" {
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid since %q", v)
+			return snapshot{}, false
+		}
+	}
+
+	// Waiting outlasts the server's WriteTimeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+	return s.waitForPoll(r.Context(), since, wait), true
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitForPoll(t *testing.T) {
+	srv := &server{}
+	srv.store(reading("48.750"))
+
+	// Many waiters are released by a single poll.
+	var wg sync.WaitGroup
+	gens := make(chan uint64, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gens <- srv.waitForPoll(context.Background(), 1, 5*time.Second).Generation
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	srv.store(reading("49.000"))
+	wg.Wait()
+	close(gens)
+	for g := range gens {
+		if g != 2 {
+			t.Errorf("waiter got generation %d, want 2", g)
+		}
+	}
+
+	start := time.Now()
+	if snap := srv.waitForPoll(context.Background(), 2, 50*time.Millisecond); snap.Generation != 2 || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("timeout: generation = %d after %s", snap.Generation, time.Since(start))
+	}
+	if snap := srv.waitForPoll(context.Background(), 1, time.Hour); snap.Generation != 2 {
+		t.Errorf("older since: generation = %d, want 2 immediately", snap.Generation)
+	}
+	if snap := srv.waitForPoll(context.Background(), 99, time.Hour); snap.Generation != 2 {
+		t.Errorf("since from before a restart: generation = %d, want 2 immediately", snap.Generation)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if snap := srv.waitForPoll(ctx, 2, time.Hour); snap.Generation != 2 {
+		t.Errorf("cancelled: generation = %d", snap.Generation)
+	}
+
+	// Shutdown releases waiters without waiting for the request
+	// context, which it does not cancel.
+	srv.done = make(chan struct{})
+	time.AfterFunc(20*time.Millisecond, func() { close(srv.done) })
+	start = time.Now()
+	if snap := srv.waitForPoll(context.Background(), 2, time.Hour); snap.Generation != 2 || time.Since(start) > time.Second {
+		t.Errorf("shutdown: generation = %d after %s", snap.Generation, time.Since(start))
+	}
+}
+
+func TestHandleSensors_LongPoll(t *testing.T) {
+	srv, mux := newAPIServer(apiSensors)
+	ts := httptest.NewUnstartedServer(mux)
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	time.AfterFunc(300*time.Millisecond, func() {
+		srv.store([]Sensor{{ID: "hot_water_middle", Value: "49.000"}})
+	})
+	start := time.Now()
+	resp, err := http.Get(ts.URL + "/sensors?wait=5s&since=1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Sensors []Sensor `json:"sensors"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Poll-Generation") != "2" ||
+		len(body.Sensors) != 1 || body.Sensors[0].Value != "49.000" {
+		t.Errorf("status = %d, generation = %q, body = %+v", resp.StatusCode, resp.Header.Get("X-Poll-Generation"), body)
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("returned after %s, before the poll", d)
+	}
+
+	for _, q := range []string{"wait=soon", "wait=1h", "wait=1s&since=x"} {
+		var apiErr apiError
+		if code := get(t, mux, "/sensors?"+q, &apiErr); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", q, code)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -20,41 +21,167 @@ const (
 	defaultW1Path       = "/sys/devices/w1_bus_master1"
 )
 
-type sensorResponse struct {
-	Sensors []Sensor `json:"sensors"`
+type server struct {
+	cache        atomic.Value
+	w1Path       string
+	iioPath      string
+	sensorMap    map[string]string
+	outputs      *dispatcher
+	pollInterval time.Duration
+	events       *eventHub
+	heartbeat    time.Duration // SSE heartbeat interval; zero uses the default
+	trigger      chan struct{} // requests an immediate poll; nil disables
+
+	wsPingInterval time.Duration // zero uses the defaults
+	wsPongTimeout  time.Duration
+
+	pollMu sync.Mutex
+	polled chan struct{} // closed by store after every poll; see waitForPoll
+	done   chan struct{} // closed when the HTTP server shuts down; ends long polls
+
+	inventory *inventory    // nil disables missing/unexpected device tracking
+	maxAge    time.Duration // poll age at which /healthz fails; zero: 3 poll intervals
+	pollToken string        // bearer token for POST /poll and POST /identify
+	flightMu  sync.Mutex
+	inflight  *pollCall
+
+	busMu       sync.Mutex // serializes bus reads by polls and identification
+	identifying sync.Mutex // held while POST /identify samples the bus
 }
 
-type server struct {
-	cache     atomic.Value
-	w1Path    string
-	iioPath   string
-	sensorMap map[string]string
+// snapshot is the cached result of a poll. Generation counts polls
+// since startup, like the eventHub's.
+type snapshot struct {
+	Sensors    []Sensor
+	Generation uint64
+	Time       time.Time
+}
+
+// store caches the result of a poll. Polls do not overlap (see poll),
+// so generations are strictly increasing.
+func (s *server) store(sensors []Sensor) snapshot {
+	s.pollMu.Lock()
+	defer s.pollMu.Unlock()
+
+	prev, _ := s.cache.Load().(snapshot)
+	snap := snapshot{Sensors: sensors, Generation: prev.Generation + 1, Time: time.Now()}
+	s.cache.Store(snap)
+	if s.polled != nil {
+		close(s.polled)
+		s.polled = nil
+	}
+	return snap
+}
+
+// snapshot returns the latest poll result; Generation is zero before
+// the first poll.
+func (s *server) snapshot() snapshot {
+	snap, _ := s.cache.Load().(snapshot)
+	return snap
 }
 
+func (s *server) sensors() []Sensor {
+	return s.snapshot().Sensors
+}
+
+// pollCall is a poll in progress that concurrent callers wait for.
+type pollCall struct {
+	done    chan struct{}
+	sensors []Sensor
+}
+
+// poll reads all sensors, caches the result and feeds it to the
+// subscribers and outputs. Callers arriving while a poll is running
+// share its result instead of accessing the bus again.
 func (s *server) poll() []Sensor {
-	sensors := ReadAll(s.w1Path, s.iioPath, s.sensorMap)
-	s.cache.Store(sensors)
-	log.Printf("polled %d sensors", len(sensors))
-	return sensors
+	s.flightMu.Lock()
+	if c := s.inflight; c != nil {
+		s.flightMu.Unlock()
+		<-c.done
+		return c.sensors
+	}
+	c := &pollCall{done: make(chan struct{})}
+	s.inflight = c
+	s.flightMu.Unlock()
+
+	defer func() {
+		s.flightMu.Lock()
+		s.inflight = nil
+		s.flightMu.Unlock()
+		close(c.done)
+	}()
+
+	s.busMu.Lock()
+	c.sensors = ReadAll(s.w1Path, s.iioPath, s.sensorMap)
+	s.busMu.Unlock()
+	snap := s.store(c.sensors)
+	log.Printf("polled %d sensors", len(c.sensors))
+	if s.inventory != nil {
+		s.inventory.Update(s.sensorMap, w1Probes(s.w1Path, s.sensorMap), c.sensors)
+	}
+	if s.events != nil {
+		s.events.Publish(snap)
+	}
+	if s.outputs != nil {
+		s.outputs.Dispatch(c.sensors)
+	}
+	return c.sensors
 }
 
-func (s *server) handleSensors(w http.ResponseWriter, r *http.Request) {
-	cached, _ := s.cache.Load().([]Sensor)
-	if cached == nil {
-		cached = []Sensor{}
+// requestPoll asks the poll loop for an immediate poll. Requests made
+// while one is already pending are merged.
+func (s *server) requestPoll() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(sensorResponse{Sensors: cached})
+}
+
+// pollLoop polls every pollInterval and whenever requestPoll is
+// called, until ctx is done. A requested poll restarts the interval,
+// so the cache lifetime announced for its result holds.
+func (s *server) pollLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.poll()
+		case <-s.trigger:
+			s.poll()
+			ticker.Reset(s.pollInterval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type healthResponse struct {
+	Status     string            `json:"status"` // "ok", "degraded" (expected sensors missing) or "no_data"
+	Sensors    int               `json:"sensors"`
+	HAQueue    *int              `json:"ha_queue,omitempty"`
+	Missing    []inventoryDevice `json:"missing,omitempty"`
+	Unexpected []inventoryDevice `json:"unexpected,omitempty"`
 }
 
 func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	cached, _ := s.cache.Load().([]Sensor)
-	status := "ok"
-	if cached == nil || len(cached) == 0 {
-		status = "no_data"
+	cached := s.sensors()
+	resp := healthResponse{Status: "ok", Sensors: len(cached)}
+	if s.inventory != nil {
+		resp.Missing = s.inventory.Missing()
+		resp.Unexpected = s.inventory.Unexpected()
+	}
+	switch {
+	case len(cached) == 0:
+		resp.Status = "no_data"
+	case len(resp.Missing) > 0:
+		resp.Status = "degraded"
+	}
+	if n, ok := s.outputs.Backlog("homeassistant"); ok {
+		resp.HAQueue = &n
 	}
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"status":"%s","sensors":%d}`, status, len(cached))
+	json.NewEncoder(w).Encode(resp)
 }
 
 func envOrDefault(key, fallback string) string {
@@ -82,7 +209,134 @@ func findIIODevice() string {
 	return ""
 }
 
+// loadOutputs builds the output dispatcher from the environment.
+// OUTPUT_QUEUE_POLICY takes "name:policy,..." pairs (as shown on
+// /outputs); outputs not listed use drop-oldest.
+func loadOutputs() (*dispatcher, error) {
+	var outputs []Output
+
+	if path := os.Getenv("HA_ENTITIES"); path != "" {
+		if err := LoadEntityConfig(path); err != nil {
+			return nil, fmt.Errorf("ha entities: %w", err)
+		}
+	}
+
+	if haURL := os.Getenv("HA_URL"); haURL != "" {
+		if haToken := os.Getenv("HA_TOKEN"); haToken != "" {
+			pusher := NewHAPusher(haURL, haToken)
+			switch transport := envOrDefault("HA_TRANSPORT", "rest"); transport {
+			case "rest":
+			case "websocket":
+				pusher.ws = NewHAWebSocket(haURL, haToken)
+				pusher.ws.eventType = envOrDefault("HA_WS_EVENT_TYPE", pusher.ws.eventType)
+			default:
+				return nil, fmt.Errorf("unknown HA_TRANSPORT %q", transport)
+			}
+			if v, err := strconv.Atoi(os.Getenv("HA_QUEUE_SIZE")); err == nil && v > 0 {
+				pusher.queue.max = v
+			}
+			if v, err := strconv.Atoi(os.Getenv("HA_QUEUE_COALESCE")); err == nil && v > 0 {
+				pusher.queue.coalesceAbove = v
+			}
+			pusher.autoEntities = os.Getenv("HA_AUTO_ENTITIES") == "1"
+			if v, err := strconv.Atoi(os.Getenv("HA_PUSH_CONCURRENCY")); err == nil && v > 0 {
+				pusher.concurrency = v
+			}
+			if v, err := strconv.Atoi(os.Getenv("HA_UNAVAILABLE_AFTER")); err == nil && v >= 0 {
+				pusher.unavailableAfter = time.Duration(v) * time.Second
+			}
+			if v, err := strconv.Atoi(os.Getenv("HA_BREAKER_THRESHOLD")); err == nil && v > 0 {
+				pusher.breaker.threshold = v
+			}
+			if v, err := strconv.Atoi(os.Getenv("HA_BREAKER_MAX_BACKOFF")); err == nil && v > 0 {
+				pusher.breaker.maxBackoff = time.Duration(v) * time.Second
+			}
+			if path := os.Getenv("HA_QUEUE_FILE"); path != "" {
+				if err := pusher.queue.Load(path); err != nil {
+					return nil, fmt.Errorf("ha queue: %w", err)
+				}
+			}
+			outputs = append(outputs, pusher)
+			log.Printf("HA push enabled: %s (%s)", haURL, envOrDefault("HA_TRANSPORT", "rest"))
+		}
+	}
+
+	if rwURL := os.Getenv("REMOTE_WRITE_URL"); rwURL != "" {
+		hostname, _ := os.Hostname()
+		writer := NewRemoteWriter(rwURL, hostname)
+		writer.username = os.Getenv("REMOTE_WRITE_USERNAME")
+		writer.password = os.Getenv("REMOTE_WRITE_PASSWORD")
+		writer.token = os.Getenv("REMOTE_WRITE_TOKEN")
+		outputs = append(outputs, writer)
+		log.Printf("remote-write enabled: %s", rwURL)
+	}
+
+	if mqttURL := os.Getenv("MQTT_URL"); mqttURL != "" {
+		publisher, err := NewMQTTPublisher(mqttURL, envOrDefault("MQTT_CLIENT_ID", "tempsensorserver"))
+		if err != nil {
+			return nil, fmt.Errorf("mqtt: %w", err)
+		}
+		if user := os.Getenv("MQTT_USERNAME"); user != "" {
+			publisher.username = user
+			publisher.password = os.Getenv("MQTT_PASSWORD")
+		}
+		publisher.topicPrefix = envOrDefault("MQTT_TOPIC_PREFIX", publisher.topicPrefix)
+		publisher.discoveryPrefix = envOrDefault("MQTT_DISCOVERY_PREFIX", publisher.discoveryPrefix)
+		outputs = append(outputs, publisher)
+		log.Printf("MQTT publish enabled: %s", publisher.addr)
+	}
+
+	if influxURL := os.Getenv("INFLUX_URL"); influxURL != "" {
+		var influx *influxWriter
+		if bucket := os.Getenv("INFLUX_BUCKET"); bucket != "" {
+			influx = NewInfluxWriterV2(influxURL, os.Getenv("INFLUX_ORG"), bucket, os.Getenv("INFLUX_TOKEN"))
+		} else {
+			influx = NewInfluxWriterV1(influxURL, envOrDefault("INFLUX_DB", "tempsensor"))
+			influx.username = os.Getenv("INFLUX_USERNAME")
+			influx.password = os.Getenv("INFLUX_PASSWORD")
+		}
+		influx.spoolPath = os.Getenv("INFLUX_SPOOL")
+		influx.gzip = os.Getenv("INFLUX_GZIP") != "0"
+		outputs = append(outputs, influx)
+		log.Printf("InfluxDB write enabled: %s", influx.writeURL)
+	}
+
+	if path := os.Getenv("WEBHOOK_CONFIG"); path != "" {
+		webhooks, err := LoadWebhooks(path)
+		if err != nil {
+			return nil, fmt.Errorf("webhooks: %w", err)
+		}
+		for _, h := range webhooks {
+			outputs = append(outputs, h)
+			log.Printf("webhook %s enabled: %s", h.name, h.url)
+		}
+	}
+
+	queueSize := defaultQueueSize
+	if v, err := strconv.Atoi(os.Getenv("OUTPUT_QUEUE_SIZE")); err == nil && v > 0 {
+		queueSize = v
+	}
+	policies, err := parseQueuePolicies(os.Getenv("OUTPUT_QUEUE_POLICY"))
+	if err != nil {
+		return nil, err
+	}
+
+	d := newDispatcher()
+	for _, out := range outputs {
+		policy, ok := policies[out.Name()]
+		if !ok {
+			policy = dropOldest
+		}
+		d.Add(out, queueSize, policy)
+	}
+	return d, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "identify" {
+		os.Exit(runIdentify(os.Args[2:]))
+	}
+
 	port := envOrDefault("PORT", defaultPort)
 	w1Path := envOrDefault("W1_PATH", defaultW1Path)
 	sensorMap := ParseSensorMap(os.Getenv("SENSOR_MAP"))
@@ -104,51 +358,95 @@ func main() {
 		w1Path:    w1Path,
 		iioPath:   iioPath,
 		sensorMap: sensorMap,
-	}
+		events:    newEventHub(),
+		trigger:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		inventory: newInventory(),
 
-	var pusher *haPusher
-	if haURL := os.Getenv("HA_URL"); haURL != "" {
-		if haToken := os.Getenv("HA_TOKEN"); haToken != "" {
-			pusher = NewHAPusher(haURL, haToken)
-			log.Printf("HA push enabled: %s", haURL)
-		}
+		pollInterval: pollInterval,
+	}
+	if v, err := strconv.Atoi(os.Getenv("EVENTS_MAX_SUBSCRIBERS")); err == nil && v > 0 {
+		srv.events.maxSubs = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("HEALTH_MAX_POLL_AGE")); err == nil && v > 0 {
+		srv.maxAge = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.Atoi(os.Getenv("EVENTS_HISTORY")); err == nil && v > 0 {
+		srv.events.historySize = v
 	}
 
-	sensors := srv.poll()
-	if pusher != nil {
-		go pusher.Push(sensors)
+	outputs, err := loadOutputs()
+	if err != nil {
+		log.Fatalf("outputs: %v", err)
 	}
+	srv.outputs = outputs
+
+	srv.poll()
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	pollDone := make(chan struct{})
 	go func() {
-		ticker := time.NewTicker(pollInterval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				sensors := srv.poll()
-				if pusher != nil {
-					go pusher.Push(sensors)
-				}
-			case <-ctx.Done():
-				return
-			}
-		}
+		defer close(pollDone)
+		srv.pollLoop(ctx)
 	}()
 
+	compress := gzipHandler
+	if os.Getenv("HTTP_GZIP") == "0" {
+		compress = func(h http.HandlerFunc) http.HandlerFunc { return h }
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/sensors", srv.handleSensors)
+	mux.HandleFunc("/{$}", srv.handleDashboard)
+	mux.HandleFunc("/history", compress(srv.handleHistory))
+	mux.HandleFunc("GET /sensors", compress(srv.handleSensors))
+	mux.HandleFunc("GET /sensors/{id}", compress(srv.handleSensor))
+	if srv.pollToken = os.Getenv("POLL_TOKEN"); srv.pollToken != "" {
+		mux.HandleFunc("POST /poll", srv.requireToken(srv.handlePoll))
+		mux.HandleFunc("POST /identify", srv.requireToken(srv.handleIdentify))
+	}
 	mux.HandleFunc("/health", srv.handleHealth)
+	mux.HandleFunc("GET /inventory", srv.handleInventory)
+	mux.HandleFunc("GET /devices", srv.handleDevices)
+	mux.HandleFunc("GET /healthz", srv.handleLiveness)
+	mux.HandleFunc("GET /readyz", srv.handleReadiness)
+	mux.HandleFunc("/outputs", srv.outputs.handleStatus)
+	mux.HandleFunc("GET /metrics", srv.handleMetrics)
+	mux.HandleFunc("/events", srv.handleEvents)
+	mux.Handle("/legacy/", http.StripPrefix("/legacy", srv.legacyMux()))
+	mux.HandleFunc("/ws", srv.handleWS)
+	if ha, ok := srv.outputs.Lookup("homeassistant").(*haPusher); ok && ha.autoEntities {
+		mux.HandleFunc("/ha/generated", ha.handleGenerated)
+	}
 
 	httpSrv := &http.Server{
 		Addr:         ":" + port,
-		Handler:      mux,
+		Handler:      jsonMuxErrors(mux),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	httpSrv.RegisterOnShutdown(srv.events.Close)
+	httpSrv.RegisterOnShutdown(func() { close(srv.done) })
+
+	// Old consumers can keep their URLs by pointing them at LEGACY_PORT.
+	var legacySrv *http.Server
+	if legacyPort := os.Getenv("LEGACY_PORT"); legacyPort != "" {
+		legacySrv = &http.Server{
+			Addr:         ":" + legacyPort,
+			Handler:      srv.legacyMux(),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+		go func() {
+			log.Printf("legacy API on :%s", legacyPort)
+			if err := legacySrv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatalf("legacy server error: %v", err)
+			}
+		}()
+	}
 
 	go func() {
 		log.Printf("starting on :%s (poll every %s, w1=%s, iio=%s)",
@@ -164,4 +462,9 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	httpSrv.Shutdown(shutdownCtx)
+	if legacySrv != nil {
+		legacySrv.Shutdown(shutdownCtx)
+	}
+	<-pollDone
+	srv.outputs.Close(5 * time.Second)
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// handleMetrics serves the latest readings and the state of the Home
+// Assistant circuit breaker in the Prometheus text format, for
+// scraping.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	writePrometheus(w, s.sensors())
+	if st, ok := s.outputs.SinkStatus("homeassistant"); ok && st.Breaker != nil {
+		writeBreakerMetrics(w, "tempsensor_ha_breaker", *st.Breaker)
+	}
+}
+
+// writeBreakerMetrics writes a breaker's status as metrics named
+// prefix_*. The open gauge is 1 while the breaker rejects calls
+// without probing.
+func writeBreakerMetrics(w io.Writer, prefix string, st breakerStatus) {
+	open := 0
+	if st.State == breakerOpen.String() {
+		open = 1
+	}
+	for _, m := range []struct {
+		name, typ, help string
+		value           uint64
+	}{
+		{"open", "gauge", "Whether the circuit breaker is open.", uint64(open)},
+		{"consecutive_failures", "gauge", "Consecutive failed pushes.", uint64(st.Failures)},
+		{"transitions_total", "counter", "Circuit breaker state changes.", st.Transitions},
+		{"opened_total", "counter", "Times the circuit breaker opened.", st.Opened},
+		{"rejected_total", "counter", "Pushes rejected by the circuit breaker.", st.Rejected},
+	} {
+		name := prefix + "_" + m.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, m.help, name, m.typ, name, m.value)
+	}
+}
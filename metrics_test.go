@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	p := NewHAPusher(ts.URL, "test-token")
+	p.breaker.threshold = 1
+	p.breaker.minBackoff = time.Hour
+	p.Push([]Sensor{{ID: "hot_water_middle", Value: "48.750"}})
+
+	d := newDispatcher()
+	d.Add(p, 10, dropOldest)
+	t.Cleanup(func() { d.Close(time.Second) })
+	srv := &server{outputs: d}
+	srv.store([]Sensor{{ID: "hot_water_middle", Value: "48.750"}})
+
+	rec := httptest.NewRecorder()
+	srv.handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"tempsensor_temperature_celsius{sensor=\"hot_water_middle\"} 48.750\n",
+		"# TYPE tempsensor_ha_breaker_open gauge\ntempsensor_ha_breaker_open 1\n",
+		"tempsensor_ha_breaker_consecutive_failures 1\n",
+		"# TYPE tempsensor_ha_breaker_transitions_total counter\ntempsensor_ha_breaker_transitions_total 1\n",
+		"tempsensor_ha_breaker_opened_total 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestHandleMetrics_NoHA(t *testing.T) {
+	srv := &server{}
+	rec := httptest.NewRecorder()
+	srv.handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "breaker") {
+		t.Errorf("breaker metrics without HA:\n%s", rec.Body)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types (upper nibble of the fixed header).
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+const (
+	defaultMQTTKeepAlive  = 60 * time.Second
+	defaultMQTTMinBackoff = time.Second
+	defaultMQTTMaxBackoff = 5 * time.Minute
+)
+
+// mqttPublisher publishes readings to an MQTT broker and announces
+// them to Home Assistant via MQTT discovery, so the entities are
+// persistent, have a unique_id and can be edited in the HA UI.
+//
+// Only the subset of MQTT 3.1.1 needed for QoS 0 publishing is
+// implemented. All messages are retained; an LWT marks the service
+// offline on the availability topic if the connection drops.
+type mqttPublisher struct {
+	addr            string
+	username        string
+	password        string
+	clientID        string
+	topicPrefix     string
+	discoveryPrefix string
+	keepAlive       time.Duration
+	minBackoff      time.Duration
+	maxBackoff      time.Duration
+
+	mu          sync.Mutex // guards connection state, shared by Push and Close
+	conn        *mqttConn
+	announced   map[string]bool
+	failures    int
+	backoff     time.Duration
+	nextAttempt time.Time
+}
+
+type mqttConn struct {
+	net.Conn
+	wmu  sync.Mutex // guards writes from Push and the keepalive loop
+	dead chan struct{}
+	once sync.Once
+}
+
+type mqttDiscoveryConfig struct {
+	Name              string       `json:"name"`
+	UniqueID          string       `json:"unique_id"`
+	ObjectID          string       `json:"object_id,omitempty"`
+	StateTopic        string       `json:"state_topic"`
+	AvailabilityTopic string       `json:"availability_topic"`
+	Unit              string       `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string       `json:"device_class,omitempty"`
+	StateClass        string       `json:"state_class"`
+	Device            mqttHADevice `json:"device"`
+}
+
+type mqttHADevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+// NewMQTTPublisher creates a publisher for a broker URL of the form
+// tcp://host:port or mqtt://host:port (port defaults to 1883).
+func NewMQTTPublisher(rawURL, clientID string) (*mqttPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse MQTT URL: %w", err)
+	}
+	if u.Scheme != "tcp" && u.Scheme != "mqtt" {
+		return nil, fmt.Errorf("unsupported MQTT scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "1883")
+	}
+
+	p := &mqttPublisher{
+		addr:            addr,
+		clientID:        clientID,
+		topicPrefix:     clientID,
+		discoveryPrefix: "homeassistant",
+		keepAlive:       defaultMQTTKeepAlive,
+		minBackoff:      defaultMQTTMinBackoff,
+		maxBackoff:      defaultMQTTMaxBackoff,
+	}
+	if u.User != nil {
+		p.username = u.User.Username()
+		p.password, _ = u.User.Password()
+	}
+	return p, nil
+}
+
+func (p *mqttPublisher) availabilityTopic() string {
+	return p.topicPrefix + "/status"
+}
+
+func (p *mqttPublisher) stateTopic(id string) string {
+	return p.topicPrefix + "/" + id + "/state"
+}
+
+func (p *mqttPublisher) discoveryTopic(id string) string {
+	return p.discoveryPrefix + "/sensor/" + p.clientID + "/" + id + "/config"
+}
+
+func (p *mqttPublisher) Name() string { return "mqtt" }
+
+func (p *mqttPublisher) Push(sensors []Sensor) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn != nil && p.conn.isDead() {
+		p.dropConnLocked(errors.New("connection lost"))
+	}
+	if p.conn == nil {
+		if wait := time.Until(p.nextAttempt); wait > 0 {
+			return fmt.Errorf("not connected, next attempt in %s", wait.Round(time.Second))
+		}
+		if err := p.connectLocked(); err != nil {
+			p.dropConnLocked(err)
+			return err
+		}
+	}
+
+	published := 0
+	for _, s := range sensors {
+		if err := p.publishSensorLocked(s); err != nil {
+			err = fmt.Errorf("publish %s: %w", s.ID, err)
+			p.dropConnLocked(err)
+			return err
+		}
+		published++
+	}
+
+	if p.failures > 0 {
+		log.Printf("mqtt: recovered after %d failures", p.failures)
+		p.failures = 0
+	}
+	p.backoff = 0
+	log.Printf("mqtt: published %d sensors", published)
+	return nil
+}
+
+func (p *mqttPublisher) publishSensorLocked(s Sensor) error {
+	if meta, ok := sensorMetaMap[s.ID]; ok && !p.announced[s.ID] {
+		payload, err := json.Marshal(p.discoveryConfig(s.ID, meta))
+		if err != nil {
+			return fmt.Errorf("marshal discovery: %w", err)
+		}
+		if err := p.conn.publish(p.discoveryTopic(s.ID), payload, true); err != nil {
+			return err
+		}
+		p.announced[s.ID] = true
+	}
+	return p.conn.publish(p.stateTopic(s.ID), []byte(s.Value), true)
+}
+
+func (p *mqttPublisher) discoveryConfig(id string, meta sensorMeta) mqttDiscoveryConfig {
+	return mqttDiscoveryConfig{
+		Name:              meta.FriendlyName,
+		UniqueID:          p.clientID + "_" + id,
+		ObjectID:          strings.TrimPrefix(meta.EntityID, "sensor."),
+		StateTopic:        p.stateTopic(id),
+		AvailabilityTopic: p.availabilityTopic(),
+		Unit:              meta.Unit,
+		DeviceClass:       meta.DeviceClass,
+		StateClass:        "measurement",
+		Device: mqttHADevice{
+			Identifiers:  []string{p.clientID},
+			Name:         "Temperature Sensor Server",
+			Model:        "DS18B20 / DHT22",
+			Manufacturer: "tempsensorserver",
+		},
+	}
+}
+
+// Close publishes the offline status and disconnects cleanly. A clean
+// DISCONNECT suppresses the LWT, so the status is sent explicitly.
+func (p *mqttPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+	p.conn.publish(p.availabilityTopic(), []byte("offline"), true)
+	err := p.conn.writePacket(mqttDisconnect<<4, nil)
+	p.conn.close()
+	p.conn = nil
+	return err
+}
+
+func (p *mqttPublisher) connectLocked() error {
+	nc, err := net.DialTimeout("tcp", p.addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	c := &mqttConn{Conn: nc, dead: make(chan struct{})}
+
+	if err := c.writePacket(mqttConnect<<4, p.connectBody()); err != nil {
+		c.close()
+		return fmt.Errorf("send connect: %w", err)
+	}
+
+	nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(nc)
+	typ, body, err := readMQTTPacket(r)
+	if err != nil {
+		c.close()
+		return fmt.Errorf("read connack: %w", err)
+	}
+	if typ>>4 != mqttConnack || len(body) != 2 {
+		c.close()
+		return fmt.Errorf("unexpected packet 0x%02x waiting for connack", typ)
+	}
+	if body[1] != 0 {
+		c.close()
+		return fmt.Errorf("connection refused, return code %d", body[1])
+	}
+	nc.SetReadDeadline(time.Time{})
+
+	if err := c.publish(p.availabilityTopic(), []byte("online"), true); err != nil {
+		c.close()
+		return fmt.Errorf("publish availability: %w", err)
+	}
+
+	go c.readLoop(r)
+	go c.pingLoop(p.keepAlive / 2)
+
+	p.conn = c
+	p.announced = make(map[string]bool)
+	log.Printf("mqtt: connected to %s", p.addr)
+	return nil
+}
+
+// dropConnLocked closes the current connection (if any), counts the
+// failure and schedules the next connection attempt with exponential
+// backoff.
+func (p *mqttPublisher) dropConnLocked(err error) {
+	if p.conn != nil {
+		p.conn.close()
+		p.conn = nil
+	}
+
+	p.failures++
+	if p.failures == 1 || p.failures%10 == 0 {
+		log.Printf("mqtt: %v (%d consecutive failures)", err, p.failures)
+	}
+
+	if p.backoff == 0 {
+		p.backoff = p.minBackoff
+	} else {
+		p.backoff = min(p.backoff*2, p.maxBackoff)
+	}
+	p.nextAttempt = time.Now().Add(p.backoff)
+}
+
+func (p *mqttPublisher) connectBody() []byte {
+	flags := byte(0x02)  // clean session
+	flags |= 0x04 | 0x20 // will flag, will retain (QoS 0)
+	if p.username != "" {
+		flags |= 0x80
+		if p.password != "" {
+			flags |= 0x40
+		}
+	}
+
+	b := appendMQTTString(nil, "MQTT")
+	b = append(b, 4, flags) // protocol level 3.1.1
+	keepAlive := int(p.keepAlive / time.Second)
+	b = append(b, byte(keepAlive>>8), byte(keepAlive))
+
+	b = appendMQTTString(b, p.clientID)
+	b = appendMQTTString(b, p.availabilityTopic())
+	b = appendMQTTString(b, "offline")
+	if p.username != "" {
+		b = appendMQTTString(b, p.username)
+		if p.password != "" {
+			b = appendMQTTString(b, p.password)
+		}
+	}
+	return b
+}
+
+func (c *mqttConn) publish(topic string, payload []byte, retain bool) error {
+	header := byte(mqttPublish << 4)
+	if retain {
+		header |= 0x01
+	}
+	body := appendMQTTString(nil, topic)
+	return c.writePacket(header, append(body, payload...))
+}
+
+func (c *mqttConn) writePacket(header byte, body []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	pkt := appendMQTTLength([]byte{header}, len(body))
+	pkt = append(pkt, body...)
+	c.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.Write(pkt)
+	return err
+}
+
+// readLoop consumes packets from the broker (PINGRESP only, as
+// nothing is subscribed) and marks the connection dead on error.
+func (c *mqttConn) readLoop(r *bufio.Reader) {
+	for {
+		if _, _, err := readMQTTPacket(r); err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+func (c *mqttConn) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.writePacket(mqttPingreq<<4, nil); err != nil {
+				c.close()
+				return
+			}
+		case <-c.dead:
+			return
+		}
+	}
+}
+
+func (c *mqttConn) close() {
+	c.once.Do(func() {
+		close(c.dead)
+		c.Conn.Close()
+	})
+}
+
+func (c *mqttConn) isDead() bool {
+	select {
+	case <-c.dead:
+		return true
+	default:
+		return false
+	}
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendMQTTLength(b []byte, n int) []byte {
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mqttMessage struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+type mqttConnectInfo struct {
+	clientID  string
+	willTopic string
+	willMsg   string
+	username  string
+	password  string
+	keepAlive int
+}
+
+// fakeBroker is a minimal in-process MQTT broker stand-in that
+// accepts connections, answers CONNECT/PINGREQ and records PUBLISHes.
+type fakeBroker struct {
+	ln         net.Listener
+	returnCode byte
+
+	mu          sync.Mutex
+	connects    []mqttConnectInfo
+	messages    []mqttMessage
+	disconnects int
+	conns       []net.Conn
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	b := &fakeBroker{ln: ln}
+	go b.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		b.dropAll()
+	})
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		c, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns = append(b.conns, c)
+		b.mu.Unlock()
+		go b.handle(c)
+	}
+}
+
+func (b *fakeBroker) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		typ, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		switch typ >> 4 {
+		case mqttConnect:
+			b.mu.Lock()
+			b.connects = append(b.connects, parseMQTTConnect(body))
+			code := b.returnCode
+			b.mu.Unlock()
+			c.Write([]byte{mqttConnack << 4, 2, 0, code})
+			if code != 0 {
+				return
+			}
+		case mqttPublish:
+			n := int(body[0])<<8 | int(body[1])
+			b.mu.Lock()
+			b.messages = append(b.messages, mqttMessage{
+				topic:   string(body[2 : 2+n]),
+				payload: string(body[2+n:]),
+				retain:  typ&0x01 != 0,
+			})
+			b.mu.Unlock()
+		case mqttPingreq:
+			c.Write([]byte{mqttPingresp << 4, 0})
+		case mqttDisconnect:
+			b.mu.Lock()
+			b.disconnects++
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) dropAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.conns {
+		c.Close()
+	}
+	b.conns = nil
+}
+
+func (b *fakeBroker) snapshot() ([]mqttConnectInfo, []mqttMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]mqttConnectInfo(nil), b.connects...), append([]mqttMessage(nil), b.messages...)
+}
+
+func (b *fakeBroker) waitMessages(t *testing.T, n int) []mqttMessage {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, msgs := b.snapshot()
+		if len(msgs) >= n || time.Now().After(deadline) {
+			return msgs
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func parseMQTTConnect(body []byte) mqttConnectInfo {
+	readString := func() string {
+		n := int(body[0])<<8 | int(body[1])
+		s := string(body[2 : 2+n])
+		body = body[2+n:]
+		return s
+	}
+	readString() // protocol name
+	flags := body[1]
+	info := mqttConnectInfo{keepAlive: int(body[2])<<8 | int(body[3])}
+	body = body[4:]
+
+	info.clientID = readString()
+	if flags&0x04 != 0 {
+		info.willTopic = readString()
+		info.willMsg = readString()
+	}
+	if flags&0x80 != 0 {
+		info.username = readString()
+	}
+	if flags&0x40 != 0 {
+		info.password = readString()
+	}
+	return info
+}
+
+func TestNewMQTTPublisher(t *testing.T) {
+	p, err := NewMQTTPublisher("mqtt://user:pw@broker.local", "pi")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.addr != "broker.local:1883" {
+		t.Errorf("addr = %q, want broker.local:1883", p.addr)
+	}
+	if p.username != "user" || p.password != "pw" {
+		t.Errorf("credentials = %q/%q, want user/pw", p.username, p.password)
+	}
+
+	if _, err := NewMQTTPublisher("http://broker.local", "pi"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
+
+func TestMQTT_ConnectAndDiscovery(t *testing.T) {
+	b := newFakeBroker(t)
+	p, _ := NewMQTTPublisher(b.url(), "tempsensorserver")
+	p.username = "ha"
+	p.password = "secret"
+
+	p.Push([]Sensor{
+		{ID: "hot_water_middle", Value: "48.750"},
+		{ID: "0", Value: "21.000"},
+	})
+	msgs := b.waitMessages(t, 4)
+	connects, _ := b.snapshot()
+
+	if len(connects) != 1 {
+		t.Fatalf("connects = %d, want 1", len(connects))
+	}
+	c := connects[0]
+	if c.clientID != "tempsensorserver" || c.username != "ha" || c.password != "secret" {
+		t.Errorf("connect = %+v", c)
+	}
+	if c.willTopic != "tempsensorserver/status" || c.willMsg != "offline" {
+		t.Errorf("will = %q/%q, want tempsensorserver/status/offline", c.willTopic, c.willMsg)
+	}
+	if c.keepAlive != 60 {
+		t.Errorf("keepalive = %d, want 60", c.keepAlive)
+	}
+
+	want := []mqttMessage{
+		{"tempsensorserver/status", "online", true},
+		{"homeassistant/sensor/tempsensorserver/hot_water_middle/config", "", true},
+		{"tempsensorserver/hot_water_middle/state", "48.750", true},
+		{"tempsensorserver/0/state", "21.000", true},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("messages = %+v, want %d", msgs, len(want))
+	}
+	for i, w := range want {
+		got := msgs[i]
+		if got.topic != w.topic || got.retain != w.retain || (w.payload != "" && got.payload != w.payload) {
+			t.Errorf("message %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	var cfg mqttDiscoveryConfig
+	if err := json.Unmarshal([]byte(msgs[1].payload), &cfg); err != nil {
+		t.Fatalf("discovery payload: %v", err)
+	}
+	if cfg.UniqueID != "tempsensorserver_hot_water_middle" {
+		t.Errorf("unique_id = %q", cfg.UniqueID)
+	}
+	if cfg.ObjectID != "warmwasser_mitte" {
+		t.Errorf("object_id = %q, want warmwasser_mitte", cfg.ObjectID)
+	}
+	if cfg.StateTopic != "tempsensorserver/hot_water_middle/state" {
+		t.Errorf("state_topic = %q", cfg.StateTopic)
+	}
+	if cfg.AvailabilityTopic != "tempsensorserver/status" {
+		t.Errorf("availability_topic = %q", cfg.AvailabilityTopic)
+	}
+	if cfg.Unit != "°C" || cfg.DeviceClass != "temperature" || cfg.StateClass != "measurement" {
+		t.Errorf("unit/class = %q/%q/%q", cfg.Unit, cfg.DeviceClass, cfg.StateClass)
+	}
+	if cfg.Name != "Warmwasser Mitte" {
+		t.Errorf("name = %q, want Warmwasser Mitte", cfg.Name)
+	}
+	if len(cfg.Device.Identifiers) != 1 || cfg.Device.Identifiers[0] != "tempsensorserver" {
+		t.Errorf("device identifiers = %v", cfg.Device.Identifiers)
+	}
+}
+
+func TestMQTT_DiscoveryOncePerConnection(t *testing.T) {
+	b := newFakeBroker(t)
+	p, _ := NewMQTTPublisher(b.url(), "pi")
+
+	sensors := []Sensor{{ID: "heating_supply", Value: "42.500"}}
+	p.Push(sensors)
+	p.Push(sensors)
+	msgs := b.waitMessages(t, 4)
+
+	configs := 0
+	for _, m := range msgs {
+		if m.topic == p.discoveryTopic("heating_supply") {
+			configs++
+		}
+	}
+	if configs != 1 {
+		t.Errorf("discovery messages = %d, want 1", configs)
+	}
+}
+
+func TestMQTT_ReconnectWithBackoff(t *testing.T) {
+	b := newFakeBroker(t)
+	p, _ := NewMQTTPublisher(b.url(), "pi")
+	p.minBackoff = 50 * time.Millisecond
+
+	sensors := []Sensor{{ID: "heating_supply", Value: "42.500"}}
+	p.Push(sensors)
+	b.waitMessages(t, 3)
+
+	b.dropAll()
+	deadline := time.Now().Add(2 * time.Second)
+	for !p.conn.isDead() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	p.Push(sensors)
+	if p.conn != nil {
+		t.Fatal("expected connection to be dropped")
+	}
+	if p.failures != 1 {
+		t.Errorf("failures = %d, want 1", p.failures)
+	}
+
+	p.Push(sensors)
+	if connects, _ := b.snapshot(); len(connects) != 1 {
+		t.Errorf("connects = %d, want 1 (still backing off)", len(connects))
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	p.Push(sensors)
+	msgs := b.waitMessages(t, 6)
+
+	connects, _ := b.snapshot()
+	if len(connects) != 2 {
+		t.Fatalf("connects = %d, want 2", len(connects))
+	}
+	if p.failures != 0 {
+		t.Errorf("failures = %d, want 0 after reconnect", p.failures)
+	}
+	if msgs[len(msgs)-2].topic != p.discoveryTopic("heating_supply") {
+		t.Errorf("expected discovery to be re-sent after reconnect, got %+v", msgs)
+	}
+}
+
+func TestMQTT_ConnectionRefused(t *testing.T) {
+	b := newFakeBroker(t)
+	b.returnCode = 5 // not authorized
+	p, _ := NewMQTTPublisher(b.url(), "pi")
+	p.minBackoff = time.Millisecond
+
+	p.Push([]Sensor{{ID: "heating_supply", Value: "42.500"}})
+	if p.conn != nil {
+		t.Fatal("expected no connection")
+	}
+	if p.failures != 1 || p.backoff != time.Millisecond {
+		t.Errorf("failures/backoff = %d/%s, want 1/1ms", p.failures, p.backoff)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	p.Push([]Sensor{{ID: "heating_supply", Value: "42.500"}})
+	if p.failures != 2 || p.backoff != 2*time.Millisecond {
+		t.Errorf("failures/backoff = %d/%s, want 2/2ms", p.failures, p.backoff)
+	}
+}
+
+func TestMQTT_Unreachable(t *testing.T) {
+	p, _ := NewMQTTPublisher("tcp://127.0.0.1:1", "pi")
+	p.Push([]Sensor{{ID: "heating_supply", Value: "42.500"}})
+	if p.failures != 1 {
+		t.Errorf("failures = %d, want 1", p.failures)
+	}
+}
+
+func TestMQTT_Close(t *testing.T) {
+	b := newFakeBroker(t)
+	p, _ := NewMQTTPublisher(b.url(), "pi")
+
+	p.Push([]Sensor{{ID: "0", Value: "21.000"}})
+	p.Close()
+	msgs := b.waitMessages(t, 3)
+
+	last := msgs[len(msgs)-1]
+	if last.topic != "pi/status" || last.payload != "offline" || !last.retain {
+		t.Errorf("last message = %+v, want retained offline status", last)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		n := b.disconnects
+		b.mu.Unlock()
+		if n == 1 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("expected DISCONNECT")
+}
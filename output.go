@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQueueSize    = 10
+	defaultBlockTimeout = 5 * time.Second
+)
+
+// Output is a destination that every poll result is pushed to. The
+// dispatcher calls Push from one goroutine per output, so calls to
+// Push never overlap.
+type Output interface {
+	Name() string
+	Push(sensors []Sensor) error
+}
+
+// backlogger is implemented by outputs that hold undelivered data of
+// their own, such as the Home Assistant retry queue.
+type backlogger interface {
+	Backlog() int
+}
+
+// breakerReporter is implemented by outputs guarded by a circuit
+// breaker.
+type breakerReporter interface {
+	BreakerStatus() breakerStatus
+}
+
+// queuePolicy decides what happens when a sink's queue is full.
+type queuePolicy string
+
+const (
+	// dropOldest discards the oldest queued poll to make room.
+	dropOldest queuePolicy = "drop-oldest"
+	// dropNewest discards the poll being dispatched.
+	dropNewest queuePolicy = "drop-newest"
+	// block waits (up to blockTimeout) for room, applying
+	// backpressure to the poll loop.
+	block queuePolicy = "block"
+)
+
+func parseQueuePolicy(s string) (queuePolicy, error) {
+	switch p := queuePolicy(s); p {
+	case dropOldest, dropNewest, block:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown queue policy %q", s)
+}
+
+// parseQueuePolicies parses "name:policy,..." into a map from output
+// name to policy. The policy follows the last colon, as output names
+// such as "webhook:nodered" contain colons themselves.
+func parseQueuePolicies(raw string) (map[string]queuePolicy, error) {
+	m := make(map[string]queuePolicy)
+	if raw == "" {
+		return m, nil
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid queue policy entry %q", entry)
+		}
+		p, err := parseQueuePolicy(strings.TrimSpace(entry[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		m[strings.TrimSpace(entry[:i])] = p
+	}
+	return m, nil
+}
+
+// sink wraps an Output with its own queue and worker goroutine, so a
+// slow output never delays the others.
+type sink struct {
+	out    Output
+	policy queuePolicy
+	queue  chan []Sensor
+
+	mu          sync.Mutex
+	delivered   uint64
+	failed      uint64
+	dropped     uint64
+	inFlight    bool
+	lastError   string
+	lastAttempt time.Time
+	lastSuccess time.Time
+	lastLatency time.Duration
+}
+
+type sinkStatus struct {
+	Name        string         `json:"name"`
+	Policy      string         `json:"policy"`
+	Queued      int            `json:"queued"`
+	QueueSize   int            `json:"queue_size"`
+	InFlight    bool           `json:"in_flight"`
+	Delivered   uint64         `json:"delivered"`
+	Failed      uint64         `json:"failed"`
+	Dropped     uint64         `json:"dropped"`
+	LastError   string         `json:"last_error,omitempty"`
+	LastAttempt *time.Time     `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time     `json:"last_success,omitempty"`
+	LastLatency string         `json:"last_latency,omitempty"`
+	Backlog     int            `json:"backlog,omitempty"`
+	Breaker     *breakerStatus `json:"breaker,omitempty"`
+}
+
+// dispatcher fans each poll result out to all configured sinks.
+type dispatcher struct {
+	sinks        []*sink
+	blockTimeout time.Duration
+	wg           sync.WaitGroup
+
+	mu     sync.RWMutex // held by Dispatch while enqueueing; Close waits for it
+	closed bool
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{blockTimeout: defaultBlockTimeout}
+}
+
+// Add registers an output and starts its worker.
+func (d *dispatcher) Add(out Output, queueSize int, policy queuePolicy) {
+	s := &sink{
+		out:    out,
+		policy: policy,
+		queue:  make(chan []Sensor, queueSize),
+	}
+	d.sinks = append(d.sinks, s)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for sensors := range s.queue {
+			s.push(sensors)
+		}
+	}()
+}
+
+// Dispatch queues sensors for every sink. Non-blocking sinks are
+// served first so a sink with the block policy cannot delay them.
+// After Close, for example from a POST /poll that outlived shutdown,
+// the sensors are discarded.
+func (d *dispatcher) Dispatch(sensors []Sensor) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, s := range d.sinks {
+		if s.policy != block {
+			s.enqueue(sensors, 0)
+		}
+	}
+	for _, s := range d.sinks {
+		if s.policy == block {
+			s.enqueue(sensors, d.blockTimeout)
+		}
+	}
+}
+
+// Close stops accepting polls and waits up to timeout for the sinks
+// to drain their queues, then closes outputs that implement
+// io.Closer.
+func (d *dispatcher) Close(timeout time.Duration) {
+	d.mu.Lock()
+	d.closed = true
+	for _, s := range d.sinks {
+		close(s.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Println("outputs: timed out draining queues")
+	}
+
+	for _, s := range d.sinks {
+		if c, ok := s.out.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+func (d *dispatcher) Status() []sinkStatus {
+	statuses := make([]sinkStatus, 0, len(d.sinks))
+	for _, s := range d.sinks {
+		statuses = append(statuses, s.status())
+	}
+	return statuses
+}
+
+// Lookup returns the output with the given name, or nil.
+func (d *dispatcher) Lookup(name string) Output {
+	if d == nil {
+		return nil
+	}
+	for _, s := range d.sinks {
+		if s.out.Name() == name {
+			return s.out
+		}
+	}
+	return nil
+}
+
+// SinkStatus returns the status of the named output.
+func (d *dispatcher) SinkStatus(name string) (sinkStatus, bool) {
+	if d == nil {
+		return sinkStatus{}, false
+	}
+	for _, s := range d.sinks {
+		if s.out.Name() == name {
+			return s.status(), true
+		}
+	}
+	return sinkStatus{}, false
+}
+
+// Backlog returns the backlog of the named output, if it keeps one.
+func (d *dispatcher) Backlog(name string) (int, bool) {
+	if b, ok := d.Lookup(name).(backlogger); ok {
+		return b.Backlog(), true
+	}
+	return 0, false
+}
+
+func (d *dispatcher) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Outputs []sinkStatus `json:"outputs"`
+	}{d.Status()})
+}
+
+func (s *sink) enqueue(sensors []Sensor, timeout time.Duration) {
+	switch s.policy {
+	case block:
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case s.queue <- sensors:
+			return
+		case <-timer.C:
+		}
+	case dropOldest:
+		for {
+			select {
+			case s.queue <- sensors:
+				return
+			default:
+			}
+			select {
+			case <-s.queue:
+				s.drop()
+			default:
+			}
+		}
+	default:
+		select {
+		case s.queue <- sensors:
+			return
+		default:
+		}
+	}
+	s.drop()
+}
+
+func (s *sink) drop() {
+	s.mu.Lock()
+	s.dropped++
+	n := s.dropped
+	s.mu.Unlock()
+	if n == 1 || n%10 == 0 {
+		log.Printf("outputs: %s queue full, dropped %d polls", s.out.Name(), n)
+	}
+}
+
+func (s *sink) push(sensors []Sensor) {
+	start := time.Now()
+	s.mu.Lock()
+	s.inFlight = true
+	s.lastAttempt = start
+	s.mu.Unlock()
+
+	err := s.out.Push(sensors)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight = false
+	s.lastLatency = time.Since(start)
+	if err != nil {
+		s.failed++
+		s.lastError = err.Error()
+		return
+	}
+	s.delivered++
+	s.lastError = ""
+	s.lastSuccess = time.Now()
+}
+
+func (s *sink) status() sinkStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := sinkStatus{
+		Name:      s.out.Name(),
+		Policy:    string(s.policy),
+		Queued:    len(s.queue),
+		QueueSize: cap(s.queue),
+		InFlight:  s.inFlight,
+		Delivered: s.delivered,
+		Failed:    s.failed,
+		Dropped:   s.dropped,
+		LastError: s.lastError,
+	}
+	if !s.lastAttempt.IsZero() {
+		t := s.lastAttempt
+		st.LastAttempt = &t
+		st.LastLatency = s.lastLatency.Round(time.Millisecond).String()
+	}
+	if !s.lastSuccess.IsZero() {
+		t := s.lastSuccess
+		st.LastSuccess = &t
+	}
+	if b, ok := s.out.(backlogger); ok {
+		st.Backlog = b.Backlog()
+	}
+	if b, ok := s.out.(breakerReporter); ok {
+		bs := b.BreakerStatus()
+		st.Breaker = &bs
+	}
+	return st
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeOutput struct {
+	name    string
+	gate    chan struct{} // if non-nil, each Push waits for a receive
+	err     error
+	mu      sync.Mutex
+	pushes  [][]Sensor
+	started chan struct{}
+	closed  bool
+}
+
+func newFakeOutput(name string) *fakeOutput {
+	return &fakeOutput{name: name, started: make(chan struct{}, 100)}
+}
+
+func (o *fakeOutput) Name() string { return o.name }
+
+func (o *fakeOutput) Push(sensors []Sensor) error {
+	o.started <- struct{}{}
+	if o.gate != nil {
+		<-o.gate
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pushes = append(o.pushes, sensors)
+	return o.err
+}
+
+func (o *fakeOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	return nil
+}
+
+func (o *fakeOutput) values() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var vals []string
+	for _, p := range o.pushes {
+		vals = append(vals, p[0].Value)
+	}
+	return vals
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func reading(v string) []Sensor {
+	return []Sensor{{ID: "0", Value: v}}
+}
+
+func TestDispatcher_FanOut(t *testing.T) {
+	a, b := newFakeOutput("a"), newFakeOutput("b")
+	d := newDispatcher()
+	d.Add(a, 10, dropOldest)
+	d.Add(b, 10, dropOldest)
+
+	d.Dispatch(reading("1"))
+	d.Dispatch(reading("2"))
+	d.Close(time.Second)
+
+	for _, o := range []*fakeOutput{a, b} {
+		if got := o.values(); len(got) != 2 || got[0] != "1" || got[1] != "2" {
+			t.Errorf("%s: pushes = %v, want [1 2]", o.name, got)
+		}
+		if !o.closed {
+			t.Errorf("%s: expected Close to be called", o.name)
+		}
+	}
+}
+
+func TestDispatcher_SlowSinkIsolated(t *testing.T) {
+	slow, fast := newFakeOutput("slow"), newFakeOutput("fast")
+	slow.gate = make(chan struct{})
+	d := newDispatcher()
+	d.Add(slow, 1, dropOldest)
+	d.Add(fast, 1, dropOldest)
+
+	for _, v := range []string{"1", "2", "3"} {
+		d.Dispatch(reading(v))
+		waitFor(t, func() bool { return len(fast.values()) > 0 && fast.values()[len(fast.values())-1] == v })
+	}
+
+	close(slow.gate)
+	d.Close(time.Second)
+
+	if got := fast.values(); len(got) != 3 {
+		t.Errorf("fast pushes = %v, want 3", got)
+	}
+}
+
+func TestDispatcher_DropOldest(t *testing.T) {
+	o := newFakeOutput("o")
+	o.gate = make(chan struct{})
+	d := newDispatcher()
+	d.Add(o, 1, dropOldest)
+
+	d.Dispatch(reading("1"))
+	<-o.started // "1" is in flight, queue is empty
+	d.Dispatch(reading("2"))
+	d.Dispatch(reading("3"))
+
+	st := d.Status()[0]
+	if st.Dropped != 1 || st.Queued != 1 || !st.InFlight {
+		t.Errorf("status = %+v, want 1 dropped, 1 queued, in flight", st)
+	}
+
+	close(o.gate)
+	d.Close(time.Second)
+	if got := o.values(); len(got) != 2 || got[1] != "3" {
+		t.Errorf("pushes = %v, want [1 3]", got)
+	}
+}
+
+func TestDispatcher_DropNewest(t *testing.T) {
+	o := newFakeOutput("o")
+	o.gate = make(chan struct{})
+	d := newDispatcher()
+	d.Add(o, 1, dropNewest)
+
+	d.Dispatch(reading("1"))
+	<-o.started
+	d.Dispatch(reading("2"))
+	d.Dispatch(reading("3"))
+
+	close(o.gate)
+	d.Close(time.Second)
+	if got := o.values(); len(got) != 2 || got[1] != "2" {
+		t.Errorf("pushes = %v, want [1 2]", got)
+	}
+	if st := d.Status()[0]; st.Dropped != 1 {
+		t.Errorf("dropped = %d, want 1", st.Dropped)
+	}
+}
+
+func TestDispatcher_BlockAppliesBackpressure(t *testing.T) {
+	o, other := newFakeOutput("o"), newFakeOutput("other")
+	o.gate = make(chan struct{})
+	d := newDispatcher()
+	d.blockTimeout = 50 * time.Millisecond
+	d.Add(o, 1, block)
+	d.Add(other, 10, dropOldest)
+
+	d.Dispatch(reading("1"))
+	<-o.started
+	d.Dispatch(reading("2"))
+
+	start := time.Now()
+	d.Dispatch(reading("3"))
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Dispatch returned after %s, expected to block", elapsed)
+	}
+	if got := other.values(); len(got) != 3 {
+		t.Errorf("other sink pushes = %v, want all 3 despite blocked sink", got)
+	}
+	if st := d.Status()[0]; st.Dropped != 1 {
+		t.Errorf("dropped = %d, want 1 after block timeout", st.Dropped)
+	}
+
+	close(o.gate)
+	d.Close(time.Second)
+}
+
+func TestDispatcher_StatusEndpoint(t *testing.T) {
+	ok, failing := newFakeOutput("ok"), newFakeOutput("failing")
+	failing.err = errors.New("boom")
+	d := newDispatcher()
+	d.Add(ok, 5, dropOldest)
+	d.Add(failing, 5, dropNewest)
+
+	d.Dispatch(reading("1"))
+	waitFor(t, func() bool {
+		st := d.Status()
+		return st[0].Delivered == 1 && st[1].Failed == 1
+	})
+
+	rec := httptest.NewRecorder()
+	d.handleStatus(rec, httptest.NewRequest("GET", "/outputs", nil))
+
+	var body struct {
+		Outputs []sinkStatus `json:"outputs"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Outputs) != 2 {
+		t.Fatalf("outputs = %d, want 2", len(body.Outputs))
+	}
+	okSt, failSt := body.Outputs[0], body.Outputs[1]
+	if okSt.Name != "ok" || okSt.Delivered != 1 || okSt.LastSuccess == nil || okSt.LastError != "" {
+		t.Errorf("ok status = %+v", okSt)
+	}
+	if failSt.Policy != "drop-newest" || failSt.Failed != 1 || failSt.LastError != "boom" || failSt.LastSuccess != nil {
+		t.Errorf("failing status = %+v", failSt)
+	}
+	if failSt.QueueSize != 5 {
+		t.Errorf("queue_size = %d, want 5", failSt.QueueSize)
+	}
+
+	d.Close(time.Second)
+}
+
+func TestParseQueuePolicy(t *testing.T) {
+	for _, s := range []string{"drop-oldest", "drop-newest", "block"} {
+		if p, err := parseQueuePolicy(s); err != nil || string(p) != s {
+			t.Errorf("parseQueuePolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := parseQueuePolicy("fifo"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestParseQueuePolicies(t *testing.T) {
+	m, err := parseQueuePolicies("influxdb:block, webhook:nodered:drop-newest")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m["influxdb"] != block || m["webhook:nodered"] != dropNewest {
+		t.Errorf("policies = %v", m)
+	}
+
+	if _, err := parseQueuePolicies("mqtt"); err == nil {
+		t.Error("expected error for entry without policy")
+	}
+	if _, err := parseQueuePolicies("mqtt:sometimes"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestDispatcher_DispatchAfterClose(t *testing.T) {
+	out := newFakeOutput("slow")
+	out.gate = make(chan struct{})
+	d := newDispatcher()
+	d.Add(out, 10, dropOldest)
+	d.Dispatch(reading("1"))
+	<-out.started
+
+	// Shutdown gives up on the stuck output; a late poll must not panic.
+	d.Close(10 * time.Millisecond)
+	d.Dispatch(reading("2"))
+	close(out.gate)
+	waitFor(t, func() bool { return len(out.values()) == 1 })
+	if v := out.values(); v[0] != "1" {
+		t.Errorf("values = %v", v)
+	}
+}
+
+// overlapOutput fails if Push is entered while another call is running.
+type overlapOutput struct {
+	active   atomic.Int32
+	overlaps atomic.Int32
+	pushes   atomic.Int32
+}
+
+func (o *overlapOutput) Name() string { return "overlap" }
+
+func (o *overlapOutput) Push(sensors []Sensor) error {
+	if o.active.Add(1) > 1 {
+		o.overlaps.Add(1)
+	}
+	defer o.active.Add(-1)
+	time.Sleep(10 * time.Millisecond)
+	o.pushes.Add(1)
+	return nil
+}
+
+func TestDispatcher_PushNeverOverlaps(t *testing.T) {
+	out := &overlapOutput{}
+	d := newDispatcher()
+	d.Add(out, 10, dropNewest)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Dispatch(reading("1"))
+		}()
+	}
+	wg.Wait()
+	d.Close(time.Second)
+
+	if n := out.overlaps.Load(); n != 0 {
+		t.Errorf("Push entered %d times while already running", n)
+	}
+	if n := out.pushes.Load(); n != 5 {
+		t.Errorf("pushes = %d, want 5", n)
+	}
+}
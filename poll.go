@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// onDemandPollTimeout bounds how long POST /poll waits for the poll
+// loop to complete the poll it requested.
+const onDemandPollTimeout = time.Minute
+
+// handlePoll has the poll loop read the sensors right away instead of
+// waiting for the next POLL_INTERVAL, which updates the cache and
+// feeds all outputs, and returns the fresh readings. Requests arriving
+// during a poll join it rather than accessing the bus again. ?id= and
+// the other /sensors parameters select what is returned; an unknown
+// ?id= is a 404.
+func (s *server) handlePoll(w http.ResponseWriter, r *http.Request) {
+	q, format, ok := parseSensorRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	log.Printf("poll: on-demand poll requested by %s", r.RemoteAddr)
+	sensors, ok := s.pollNow(r.Context())
+	if !ok {
+		writeJSONError(w, http.StatusServiceUnavailable, "poll did not complete")
+		return
+	}
+
+	for _, id := range q.ids {
+		if !slices.ContainsFunc(sensors, func(sn Sensor) bool { return sn.ID == id }) {
+			writeJSONError(w, http.StatusNotFound, "sensor %q not found", id)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeSensorList(w, format, q, sensors)
+}
+
+// pollNow requests a poll from the poll loop, which then restarts its
+// interval, and waits for the result. Without a poll loop (trigger is
+// nil) it polls itself. It returns false if ctx is done, the server
+// shuts down or onDemandPollTimeout elapses first.
+func (s *server) pollNow(ctx context.Context) ([]Sensor, bool) {
+	if s.trigger == nil {
+		return s.poll(), true
+	}
+	since := s.snapshot().Generation
+	s.requestPoll()
+	snap := s.waitForPoll(ctx, since, onDemandPollTimeout)
+	return snap.Sensors, snap.Generation != since
+}
+
+// requireToken wraps the handlers of routes that access the bus on
+// demand, replying 401 unless the request carries POLL_TOKEN as a
+// bearer token.
+func (s *server) requireToken(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tempsensorserver"`)
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.pollToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(s.pollToken)) == 1
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newPollServer(t *testing.T) (*server, *fakeOutput, http.Handler) {
+	out := newFakeOutput("fake")
+	d := newDispatcher()
+	d.Add(out, 10, dropOldest)
+	t.Cleanup(func() { d.Close(time.Second) })
+
+	srv := &server{
+		w1Path:    "testdata/w1_bus_master1",
+		iioPath:   "testdata/iio_device",
+		sensorMap: map[string]string{"28-000000000001": "hot_water_middle"},
+		outputs:   d,
+		events:    newEventHub(),
+		pollToken: "s3cret",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /poll", srv.requireToken(srv.handlePoll))
+	return srv, out, mux
+}
+
+func postPoll(h http.Handler, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlePoll(t *testing.T) {
+	srv, out, mux := newPollServer(t)
+
+	for _, token := range []string{"", "wrong"} {
+		if rec := postPoll(mux, "/poll", token); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("token %q: status = %d", token, rec.Code)
+		}
+	}
+	if srv.snapshot().Generation != 0 {
+		t.Fatal("unauthorized request polled the sensors")
+	}
+
+	rec := postPoll(mux, "/poll?id=hot_water_middle", "s3cret")
+	var body struct {
+		Sensors []Sensor `json:"sensors"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusOK || len(body.Sensors) != 1 || body.Sensors[0].Value != "48.750" {
+		t.Errorf("status = %d, body = %+v", rec.Code, body)
+	}
+	if srv.snapshot().Generation != 1 || len(srv.sensors()) != 6 {
+		t.Errorf("cache: generation = %d, %d sensors", srv.snapshot().Generation, len(srv.sensors()))
+	}
+	if ev, ok := srv.events.Latest(); !ok || ev.Generation != 1 {
+		t.Error("poll not published to subscribers")
+	}
+	waitFor(t, func() bool { return len(out.values()) == 1 })
+
+	if rec := postPoll(mux, "/poll?id=garage", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown id: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandlePoll_Coalesced(t *testing.T) {
+	srv, _, mux := newPollServer(t)
+
+	// Requests arriving during a poll wait for it instead of starting
+	// their own.
+	call := &pollCall{done: make(chan struct{})}
+	srv.inflight = call
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- postPoll(mux, "/poll?format=text", "s3cret").Code
+		}()
+	}
+	time.Sleep(200 * time.Millisecond)
+	srv.flightMu.Lock()
+	call.sensors = reading("21.000")
+	srv.inflight = nil
+	srv.flightMu.Unlock()
+	close(call.done)
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("status = %d", code)
+		}
+	}
+	if gen := srv.snapshot().Generation; gen != 0 {
+		t.Errorf("generation = %d: coalesced requests read the bus themselves", gen)
+	}
+}
+
+func TestHandlePoll_RestartsInterval(t *testing.T) {
+	srv, _, mux := newPollServer(t)
+	srv.pollInterval = 1500 * time.Millisecond
+	srv.trigger = make(chan struct{}, 1)
+	mux.(*http.ServeMux).HandleFunc("GET /sensors", srv.handleSensors)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.pollLoop(ctx)
+
+	// Poll on demand partway through the interval.
+	time.Sleep(600 * time.Millisecond)
+	if rec := postPoll(mux, "/poll", "s3cret"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	polled := srv.snapshot()
+	if polled.Generation != 1 {
+		t.Fatalf("generation = %d, want 1", polled.Generation)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/sensors", nil))
+	maxAge, err := strconv.Atoi(strings.TrimPrefix(rec.Header().Get("Cache-Control"), "max-age="))
+	if err != nil || maxAge < 1 {
+		t.Fatalf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+	}
+
+	// The next tick must not come before the response expires.
+	next := srv.waitForPoll(ctx, polled.Generation, 5*time.Second)
+	if next.Generation != 2 {
+		t.Fatalf("no poll after %d", polled.Generation)
+	}
+	if expires := polled.Time.Add(time.Duration(maxAge) * time.Second); next.Time.Before(expires) {
+		t.Errorf("next poll %s after the on-demand one, but max-age=%d", next.Time.Sub(polled.Time), maxAge)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRWBatchSize  = 500
+	defaultRWMaxPending = 10000
+	rwAttempts          = 3
+)
+
+type rwLabel struct {
+	Name  string
+	Value string
+}
+
+type rwSample struct {
+	Labels    []rwLabel
+	Value     float64
+	Timestamp int64 // milliseconds since epoch
+}
+
+// remoteWriter pushes readings to a Prometheus remote-write endpoint
+// (Prometheus, VictoriaMetrics, Mimir, ...). Samples are queued on
+// every poll and sent in batches; batches that fail are kept and
+// retried on the next push, up to maxPending samples.
+type remoteWriter struct {
+	url        string
+	username   string
+	password   string
+	token      string
+	instance   string
+	client     *http.Client
+	batchSize  int
+	maxPending int
+	retryDelay time.Duration
+
+	mu      sync.Mutex // guards pending
+	pending []rwSample
+
+	failures int
+}
+
+func NewRemoteWriter(url, instance string) *remoteWriter {
+	return &remoteWriter{
+		url:        url,
+		instance:   instance,
+		batchSize:  defaultRWBatchSize,
+		maxPending: defaultRWMaxPending,
+		retryDelay: time.Second,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+func (w *remoteWriter) Name() string { return "remote-write" }
+
+func (w *remoteWriter) Push(sensors []Sensor) error {
+	w.enqueue(sensors, time.Now())
+
+	sent := 0
+	for {
+		batch := w.takeBatch()
+		if len(batch) == 0 {
+			break
+		}
+		err := withRetry(rwAttempts, w.retryDelay, func() error {
+			return w.send(batch)
+		})
+		if err == nil {
+			sent += len(batch)
+			continue
+		}
+
+		w.failures++
+		if w.failures == 1 || w.failures%10 == 0 {
+			log.Printf("remote-write: push failed (%d consecutive): %v", w.failures, err)
+		}
+		if isRetryable(err) {
+			w.requeue(batch)
+		} else {
+			log.Printf("remote-write: dropping %d samples rejected by receiver", len(batch))
+		}
+		return err
+	}
+
+	if sent > 0 && w.failures > 0 {
+		log.Printf("remote-write: recovered after %d failures", w.failures)
+		w.failures = 0
+	}
+	return nil
+}
+
+func (w *remoteWriter) enqueue(sensors []Sensor, now time.Time) {
+	ts := now.UnixMilli()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, s := range sensors {
+		val, err := strconv.ParseFloat(s.Value, 64)
+		if err != nil {
+			log.Printf("remote-write: skipping %s: invalid value %q", s.ID, s.Value)
+			continue
+		}
+		w.pending = append(w.pending, rwSample{
+			Labels:    w.labels(s),
+			Value:     val,
+			Timestamp: ts,
+		})
+	}
+	w.trimLocked()
+}
+
+func (w *remoteWriter) takeBatch() []rwSample {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := min(len(w.pending), w.batchSize)
+	batch := w.pending[:n:n]
+	w.pending = w.pending[n:]
+	return batch
+}
+
+func (w *remoteWriter) requeue(batch []rwSample) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(batch, w.pending...)
+	w.trimLocked()
+}
+
+func (w *remoteWriter) trimLocked() {
+	if over := len(w.pending) - w.maxPending; over > 0 {
+		log.Printf("remote-write: queue full, dropping %d oldest samples", over)
+		w.pending = w.pending[over:]
+	}
+}
+
+// labels returns the sorted label set for a sensor, with __name__
+// first as required by the remote-write spec.
+func (w *remoteWriter) labels(s Sensor) []rwLabel {
+	labels := []rwLabel{
+		{"__name__", metricName(s)},
+	}
+	if w.instance != "" {
+		labels = append(labels, rwLabel{"instance", w.instance})
+	}
+	return append(labels,
+		rwLabel{"job", "tempsensorserver"},
+		rwLabel{"sensor", s.ID},
+	)
+}
+
+// metricName names a sensor's metric after its kind, so it does not
+// change when an entity mapping is added for the sensor later.
+func metricName(s Sensor) string {
+	switch sensorKind(s) {
+	case "temperature":
+		return "tempsensor_temperature_celsius"
+	case "humidity":
+		return "tempsensor_humidity_percent"
+	}
+	return "tempsensor_value"
+}
+
+func (w *remoteWriter) send(batch []rwSample) error {
+	body := snappyEncode(encodeWriteRequest(batch))
+
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case w.token != "":
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	case w.username != "":
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return retryable(fmt.Errorf("do request: %w", err))
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		err := fmt.Errorf("unexpected status %d", resp.StatusCode)
+		if retryableStatus(resp.StatusCode) {
+			return retryable(err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// encodeWriteRequest serializes samples as a prometheus.WriteRequest
+// protobuf message. Samples sharing a label set are grouped into one
+// time series, preserving their order.
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(samples []rwSample) []byte {
+	type series struct {
+		labels  []rwLabel
+		samples []rwSample
+	}
+	var order []string
+	bySeries := make(map[string]*series)
+	for _, s := range samples {
+		key := fmt.Sprint(s.Labels)
+		ts, ok := bySeries[key]
+		if !ok {
+			ts = &series{labels: s.Labels}
+			bySeries[key] = ts
+			order = append(order, key)
+		}
+		ts.samples = append(ts.samples, s)
+	}
+
+	var out []byte
+	for _, key := range order {
+		ts := bySeries[key]
+		var msg []byte
+		for _, l := range ts.labels {
+			var label []byte
+			label = appendProtoString(label, 1, l.Name)
+			label = appendProtoString(label, 2, l.Value)
+			msg = appendProtoBytes(msg, 1, label)
+		}
+		for _, s := range ts.samples {
+			var sample []byte
+			sample = appendProtoDouble(sample, 1, s.Value)
+			sample = appendProtoVarint(sample, 2, uint64(s.Timestamp))
+			msg = appendProtoBytes(msg, 2, sample)
+		}
+		out = appendProtoBytes(out, 1, msg)
+	}
+	return out
+}
+
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|0)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendProtoDouble(b []byte, field int, v float64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|1)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+func appendProtoBytes(b []byte, field int, p []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+func appendProtoString(b []byte, field int, s string) []byte {
+	return appendProtoBytes(b, field, []byte(s))
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type decodedSeries struct {
+	labels map[string]string
+	values []float64
+	stamps []int64
+}
+
+type protoField struct {
+	num  int
+	data []byte
+	v    uint64
+}
+
+// protoFields splits a protobuf message into its fields. Only the
+// wire types used by remote-write are supported.
+func protoFields(t *testing.T, b []byte) []protoField {
+	t.Helper()
+	var out []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.v, n = binary.Uvarint(b)
+			b = b[n:]
+		case 1:
+			f.v = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			b = b[n:]
+			f.data = b[:l]
+			b = b[l:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func decodeWriteRequest(t *testing.T, body []byte) []decodedSeries {
+	t.Helper()
+	raw, err := snappyDecode(body)
+	if err != nil {
+		t.Fatalf("snappy decode: %v", err)
+	}
+
+	var result []decodedSeries
+	for _, ts := range protoFields(t, raw) {
+		s := decodedSeries{labels: make(map[string]string)}
+		for _, f := range protoFields(t, ts.data) {
+			switch f.num {
+			case 1:
+				var name, value string
+				for _, lf := range protoFields(t, f.data) {
+					if lf.num == 1 {
+						name = string(lf.data)
+					} else {
+						value = string(lf.data)
+					}
+				}
+				s.labels[name] = value
+			case 2:
+				for _, sf := range protoFields(t, f.data) {
+					if sf.num == 1 {
+						s.values = append(s.values, math.Float64frombits(sf.v))
+					} else {
+						s.stamps = append(s.stamps, int64(sf.v))
+					}
+				}
+			}
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+type rwReceiver struct {
+	mu       sync.Mutex
+	requests [][]decodedSeries
+	headers  []http.Header
+}
+
+func newRWReceiver(t *testing.T, status func(n int) int) (*httptest.Server, *rwReceiver) {
+	rec := &rwReceiver{}
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		calls++
+		code := status(calls)
+		if code == http.StatusNoContent {
+			rec.requests = append(rec.requests, decodeWriteRequest(t, body))
+			rec.headers = append(rec.headers, r.Header.Clone())
+		}
+		rec.mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	return ts, rec
+}
+
+func alwaysOK(int) int { return http.StatusNoContent }
+
+func TestRemoteWrite_Success(t *testing.T) {
+	ts, rec := newRWReceiver(t, alwaysOK)
+	defer ts.Close()
+
+	w := NewRemoteWriter(ts.URL, "pi")
+	w.token = "secret"
+	w.Push([]Sensor{
+		{ID: "hot_water_middle", Value: "48.750"},
+		{ID: "utility_room_humidity", Value: "49.3"},
+	})
+
+	if len(rec.requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(rec.requests))
+	}
+	h := rec.headers[0]
+	if h.Get("Content-Encoding") != "snappy" {
+		t.Errorf("content-encoding = %q, want snappy", h.Get("Content-Encoding"))
+	}
+	if h.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("content-type = %q, want application/x-protobuf", h.Get("Content-Type"))
+	}
+	if h.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		t.Errorf("version = %q, want 0.1.0", h.Get("X-Prometheus-Remote-Write-Version"))
+	}
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Errorf("auth = %q, want Bearer secret", h.Get("Authorization"))
+	}
+
+	series := rec.requests[0]
+	if len(series) != 2 {
+		t.Fatalf("series = %d, want 2", len(series))
+	}
+	temp := series[0]
+	if temp.labels["__name__"] != "tempsensor_temperature_celsius" {
+		t.Errorf("__name__ = %q, want tempsensor_temperature_celsius", temp.labels["__name__"])
+	}
+	if temp.labels["sensor"] != "hot_water_middle" {
+		t.Errorf("sensor = %q, want hot_water_middle", temp.labels["sensor"])
+	}
+	if temp.labels["instance"] != "pi" {
+		t.Errorf("instance = %q, want pi", temp.labels["instance"])
+	}
+	if len(temp.values) != 1 || temp.values[0] != 48.75 {
+		t.Errorf("values = %v, want [48.75]", temp.values)
+	}
+	if len(temp.stamps) != 1 || time.Since(time.UnixMilli(temp.stamps[0])) > time.Minute {
+		t.Errorf("timestamps = %v, want one recent timestamp", temp.stamps)
+	}
+	if series[1].labels["__name__"] != "tempsensor_humidity_percent" {
+		t.Errorf("__name__ = %q, want tempsensor_humidity_percent", series[1].labels["__name__"])
+	}
+}
+
+func TestRemoteWrite_BasicAuth(t *testing.T) {
+	ts, rec := newRWReceiver(t, alwaysOK)
+	defer ts.Close()
+
+	w := NewRemoteWriter(ts.URL, "")
+	w.username = "user"
+	w.password = "pass"
+	w.Push([]Sensor{{ID: "0", Value: "21.000"}})
+
+	if len(rec.headers) != 1 {
+		t.Fatalf("requests = %d, want 1", len(rec.headers))
+	}
+	req := &http.Request{Header: rec.headers[0]}
+	user, pass, ok := req.BasicAuth()
+	if !ok || user != "user" || pass != "pass" {
+		t.Errorf("basic auth = %q/%q (%v), want user/pass", user, pass, ok)
+	}
+	if _, ok := rec.requests[0][0].labels["instance"]; ok {
+		t.Error("instance label should be omitted when empty")
+	}
+}
+
+func TestRemoteWrite_Batching(t *testing.T) {
+	ts, rec := newRWReceiver(t, alwaysOK)
+	defer ts.Close()
+
+	w := NewRemoteWriter(ts.URL, "pi")
+	w.batchSize = 2
+	w.Push([]Sensor{
+		{ID: "0", Value: "1.0"},
+		{ID: "1", Value: "2.0"},
+		{ID: "2", Value: "3.0"},
+	})
+
+	if len(rec.requests) != 2 {
+		t.Fatalf("requests = %d, want 2", len(rec.requests))
+	}
+	if len(rec.requests[0]) != 2 || len(rec.requests[1]) != 1 {
+		t.Errorf("batch sizes = %d,%d, want 2,1", len(rec.requests[0]), len(rec.requests[1]))
+	}
+}
+
+func TestRemoteWrite_RetryOn5xx(t *testing.T) {
+	ts, rec := newRWReceiver(t, func(n int) int {
+		if n == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusNoContent
+	})
+	defer ts.Close()
+
+	w := NewRemoteWriter(ts.URL, "pi")
+	w.retryDelay = time.Millisecond
+	w.Push([]Sensor{{ID: "0", Value: "1.0"}})
+
+	if len(rec.requests) != 1 {
+		t.Fatalf("delivered requests = %d, want 1", len(rec.requests))
+	}
+	if w.failures != 0 {
+		t.Errorf("failures = %d, want 0", w.failures)
+	}
+}
+
+func TestRemoteWrite_NoRetryOn4xx(t *testing.T) {
+	calls := 0
+	ts, _ := newRWReceiver(t, func(n int) int {
+		calls = n
+		return http.StatusBadRequest
+	})
+	defer ts.Close()
+
+	w := NewRemoteWriter(ts.URL, "pi")
+	w.retryDelay = time.Millisecond
+	w.Push([]Sensor{{ID: "0", Value: "1.0"}})
+
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	if len(w.pending) != 0 {
+		t.Errorf("pending = %d, want 0 (rejected batch dropped)", len(w.pending))
+	}
+}
+
+func TestRemoteWrite_QueueWhileDown(t *testing.T) {
+	down := true
+	ts, rec := newRWReceiver(t, func(int) int {
+		if down {
+			return http.StatusBadGateway
+		}
+		return http.StatusNoContent
+	})
+	defer ts.Close()
+
+	w := NewRemoteWriter(ts.URL, "pi")
+	w.retryDelay = time.Millisecond
+	w.Push([]Sensor{{ID: "0", Value: "1.0"}})
+	w.Push([]Sensor{{ID: "0", Value: "2.0"}})
+
+	if w.failures != 2 {
+		t.Errorf("failures = %d, want 2", w.failures)
+	}
+	if len(w.pending) != 2 {
+		t.Fatalf("pending = %d, want 2", len(w.pending))
+	}
+
+	down = false
+	w.Push([]Sensor{{ID: "0", Value: "3.0"}})
+
+	if len(rec.requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(rec.requests))
+	}
+	series := rec.requests[0]
+	if len(series) != 1 {
+		t.Fatalf("series = %d, want 1 (same labels grouped)", len(series))
+	}
+	want := []float64{1, 2, 3}
+	for i, v := range want {
+		if i >= len(series[0].values) || series[0].values[i] != v {
+			t.Fatalf("values = %v, want %v", series[0].values, want)
+		}
+	}
+	if w.failures != 0 {
+		t.Errorf("failures = %d, want 0 after recovery", w.failures)
+	}
+}
+
+func TestRemoteWrite_MaxPending(t *testing.T) {
+	w := NewRemoteWriter("http://192.0.2.1:1", "pi")
+	w.client.Timeout = 50 * time.Millisecond
+	w.retryDelay = time.Millisecond
+	w.maxPending = 2
+
+	w.Push([]Sensor{
+		{ID: "0", Value: "1.0"},
+		{ID: "1", Value: "2.0"},
+		{ID: "2", Value: "3.0"},
+	})
+
+	if len(w.pending) != 2 {
+		t.Fatalf("pending = %d, want 2", len(w.pending))
+	}
+	if w.pending[0].Value != 2 {
+		t.Errorf("oldest pending value = %v, want 2 (oldest dropped)", w.pending[0].Value)
+	}
+}
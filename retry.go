@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// retryableError marks a failure that is worth another attempt, such
+// as a network error or a 5xx response.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+func retryable(err error) error {
+	return &retryableError{err: err}
+}
+
+func isRetryable(err error) bool {
+	var re *retryableError
+	return errors.As(err, &re)
+}
+
+// retryableStatus reports whether an HTTP status code indicates a
+// transient failure on the receiving side.
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// withRetry calls fn up to attempts times, doubling delay between
+// attempts, until it succeeds or returns a non-retryable error.
+func withRetry(attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(delay << (i - 1))
+		}
+		if err = fn(); err == nil || !isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
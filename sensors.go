@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -9,15 +10,73 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Sensor struct {
 	ID    string `json:"id"`
 	Value string `json:"value"`
+
+	// Address identifies the physical device: the 1-Wire device
+	// directory name or the IIO device name.
+	Address string `json:"-"`
+	// Driver is the kernel interface the value was read through
+	// ("w1" or "iio"); Model the device type, e.g. "DS18B20".
+	Driver string `json:"-"`
+	Model  string `json:"-"`
+	// Resolution is the conversion resolution in bits, 0 if unknown.
+	Resolution int       `json:"-"`
+	ReadAt     time.Time `json:"-"`
+	// Index is the position of a 1-Wire device on the bus in address
+	// order, which unmapped sensors are numbered by.
+	Index int `json:"-"`
+}
+
+// w1Models maps 1-Wire family codes to device models.
+var w1Models = map[string]string{
+	"10": "DS18S20",
+	"22": "DS1822",
+	"28": "DS18B20",
+	"3b": "MAX31850",
+	"42": "DS28EA00",
 }
 
 var tempRegexp = regexp.MustCompile(`(?m)t=(-?\d+)\s*$`)
 
+// readError is the most recent failure reading a sensor.
+type readError struct {
+	Err   string
+	At    time.Time
+	Count int // failed reads since startup
+}
+
+// readErrorLog records read failures per sensor ID so outputs can
+// report why a sensor is missing.
+type readErrorLog struct {
+	mu   sync.Mutex
+	errs map[string]readError
+}
+
+var readErrors = &readErrorLog{errs: make(map[string]readError)}
+
+func (l *readErrorLog) record(id string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := l.errs[id]
+	e.Err = err.Error()
+	e.At = time.Now()
+	e.Count++
+	l.errs[id] = e
+}
+
+func (l *readErrorLog) Get(id string) (readError, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.errs[id]
+	return e, ok
+}
+
 // ParseSensorMap parses "addr1:id1,addr2:id2,..." into
 // a map from device directory name to sensor ID.
 func ParseSensorMap(raw string) map[string]string {
@@ -34,59 +93,103 @@ func ParseSensorMap(raw string) map[string]string {
 	return m
 }
 
-func ReadDS18B20(basePath string, sensorMap map[string]string) []Sensor {
+// sensorMapEntries formats a sensor map as sorted "addr:id" entries,
+// the SENSOR_MAP syntax.
+func sensorMapEntries(m map[string]string) []string {
+	entries := make([]string, 0, len(m))
+	for addr, id := range m {
+		entries = append(entries, addr+":"+id)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// w1Devices returns the device directories of the DS18B20s on the
+// bus, sorted by address.
+func w1Devices(basePath string) []string {
 	pattern := filepath.Join(basePath, "28-*")
 	dirs, err := filepath.Glob(pattern)
 	if err != nil {
 		log.Printf("error globbing %s: %v", pattern, err)
 		return nil
 	}
-
 	sort.Strings(dirs)
+	return dirs
+}
 
-	var sensors []Sensor
-	for i, dir := range dirs {
-		path := filepath.Join(dir, "w1_slave")
-		data, err := os.ReadFile(path)
-		if err != nil {
-			log.Printf("error reading %s: %v", path, err)
-			continue
-		}
-
-		content := string(data)
-		if !strings.Contains(content, "YES") {
-			log.Printf("CRC check failed for %s", path)
-			continue
-		}
+// w1Probe is a DS18B20 on the bus and the ID its readings are served
+// under.
+type w1Probe struct {
+	Dir     string
+	Address string
+	ID      string
+	Mapped  bool // ID comes from SENSOR_MAP
+	Index   int  // position among the DS18B20s in address order
+}
 
-		match := tempRegexp.FindStringSubmatch(content)
-		if match == nil {
-			log.Printf("no temperature found in %s", path)
-			continue
+// w1Probes lists the DS18B20s on the bus. Those not in sensorMap are
+// numbered by their position on the bus.
+func w1Probes(basePath string, sensorMap map[string]string) []w1Probe {
+	dirs := w1Devices(basePath)
+	probes := make([]w1Probe, len(dirs))
+	for i, dir := range dirs {
+		addr := filepath.Base(dir)
+		probes[i] = w1Probe{Dir: dir, Address: addr, ID: strconv.Itoa(i), Index: i}
+		if id, ok := sensorMap[addr]; ok {
+			probes[i].ID, probes[i].Mapped = id, true
 		}
+	}
+	return probes
+}
 
-		millideg, err := strconv.ParseInt(match[1], 10, 64)
+func ReadDS18B20(basePath string, sensorMap map[string]string) []Sensor {
+	var sensors []Sensor
+	for _, p := range w1Probes(basePath, sensorMap) {
+		millideg, err := readW1Slave(p.Dir)
 		if err != nil {
-			log.Printf("invalid temperature in %s: %v", path, err)
+			log.Printf("error reading %s: %v", p.Address, err)
+			readErrors.record(p.ID, err)
 			continue
 		}
 
-		addr := filepath.Base(dir)
-		id := strconv.Itoa(i)
-		if mapped, ok := sensorMap[addr]; ok {
-			id = mapped
-		}
-
 		value := fmt.Sprintf("%.3f", float64(millideg)/1000.0)
 		sensors = append(sensors, Sensor{
-			ID:    id,
-			Value: value,
+			ID:         p.ID,
+			Value:      value,
+			Address:    p.Address,
+			Driver:     "w1",
+			Model:      w1Model(p.Address),
+			Resolution: readW1Resolution(p.Dir),
+			ReadAt:     time.Now(),
+			Index:      p.Index,
 		})
 	}
 
 	return sensors
 }
 
+// readW1Slave reads a DS18B20's w1_slave file and returns the
+// temperature in millidegrees Celsius, failing if the CRC check did.
+func readW1Slave(dir string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "w1_slave"))
+	if err != nil {
+		return 0, err
+	}
+	content := string(data)
+	if !strings.Contains(content, "YES") {
+		return 0, errors.New("CRC check failed")
+	}
+	match := tempRegexp.FindStringSubmatch(content)
+	if match == nil {
+		return 0, errors.New("no temperature in w1_slave")
+	}
+	millideg, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid temperature: %w", err)
+	}
+	return millideg, nil
+}
+
 func ReadDHT22(iioPath string) []Sensor {
 	if iioPath == "" {
 		return nil
@@ -95,29 +198,64 @@ func ReadDHT22(iioPath string) []Sensor {
 	tempFile := filepath.Join(iioPath, "in_temp_input")
 	humFile := filepath.Join(iioPath, "in_humidityrelative_input")
 
+	addr := filepath.Base(iioPath)
 	var sensors []Sensor
 
 	if val, err := readIIOValue(tempFile); err == nil {
 		sensors = append(sensors, Sensor{
-			ID:    "utility_room_temperature",
-			Value: val,
+			ID:      "utility_room_temperature",
+			Value:   val,
+			Address: addr,
+			Driver:  "iio",
+			Model:   "DHT22",
+			ReadAt:  time.Now(),
 		})
 	} else {
 		log.Printf("error reading DHT22 temp: %v", err)
+		readErrors.record("utility_room_temperature", err)
 	}
 
 	if val, err := readIIOValue(humFile); err == nil {
 		sensors = append(sensors, Sensor{
-			ID:    "utility_room_humidity",
-			Value: val,
+			ID:      "utility_room_humidity",
+			Value:   val,
+			Address: addr,
+			Driver:  "iio",
+			Model:   "DHT22",
+			ReadAt:  time.Now(),
 		})
 	} else {
 		log.Printf("error reading DHT22 humidity: %v", err)
+		readErrors.record("utility_room_humidity", err)
 	}
 
 	return sensors
 }
 
+// w1Model derives the device model from the family code prefix of a
+// 1-Wire address such as "28-0316a2794cff".
+func w1Model(addr string) string {
+	family, _, _ := strings.Cut(addr, "-")
+	if m, ok := w1Models[strings.ToLower(family)]; ok {
+		return m
+	}
+	return "family " + family
+}
+
+// readW1Resolution reads the resolution attribute w1_therm exposes on
+// newer kernels; it returns 0 if it is not available.
+func readW1Resolution(dir string) int {
+	data, err := os.ReadFile(filepath.Join(dir, "resolution"))
+	if err != nil {
+		return 0
+	}
+	bits, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return bits
+}
+
 func readIIOValue(path string) (string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
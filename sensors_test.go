@@ -56,6 +56,9 @@ func TestReadDS18B20_WithMap(t *testing.T) {
 		if got.Value != want.value {
 			t.Errorf("sensor %d: value = %q, want %q", i, got.Value, want.value)
 		}
+		if wantAddr := "28-00000000000" + string(rune('1'+i)); got.Address != wantAddr {
+			t.Errorf("sensor %d: address = %q, want %q", i, got.Address, wantAddr)
+		}
 	}
 }
 
@@ -83,10 +86,14 @@ func TestReadDS18B20_CRCFailure(t *testing.T) {
 		0644,
 	)
 
-	sensors := ReadDS18B20(dir, nil)
+	before, _ := readErrors.Get("tank_probe")
+	sensors := ReadDS18B20(dir, map[string]string{"28-0000000bad01": "tank_probe"})
 	if len(sensors) != 0 {
 		t.Errorf("expected 0 sensors on CRC failure, got %d", len(sensors))
 	}
+	if e, ok := readErrors.Get("tank_probe"); !ok || e.Err != "CRC check failed" || e.Count != before.Count+1 {
+		t.Errorf("read error = %+v, want CRC failure recorded", e)
+	}
 }
 
 func TestReadDS18B20_EmptyDir(t *testing.T) {
@@ -116,6 +123,33 @@ func TestReadDS18B20_NegativeTemp(t *testing.T) {
 	}
 }
 
+func TestReadDS18B20_DeviceMetadata(t *testing.T) {
+	dir := t.TempDir()
+	sensorDir := filepath.Join(dir, "28-0316a2794cff")
+	os.MkdirAll(sensorDir, 0755)
+	os.WriteFile(
+		filepath.Join(sensorDir, "w1_slave"),
+		[]byte("33 00 4b 46 ff ff 02 10 f4 : crc=f4 YES\n33 00 4b 46 ff ff 02 10 f4 t=48750\n"),
+		0644,
+	)
+	os.WriteFile(filepath.Join(sensorDir, "resolution"), []byte("12\n"), 0644)
+
+	sensors := ReadDS18B20(dir, nil)
+	if len(sensors) != 1 {
+		t.Fatalf("expected 1 sensor, got %d", len(sensors))
+	}
+	s := sensors[0]
+	if s.Driver != "w1" || s.Model != "DS18B20" || s.Resolution != 12 || s.ReadAt.IsZero() {
+		t.Errorf("sensor = %+v, want w1 DS18B20 at 12 bits with read time", s)
+	}
+	if m := w1Model("3b-000000000001"); m != "MAX31850" {
+		t.Errorf("w1Model(3b) = %q", m)
+	}
+	if m := w1Model("ff-000000000001"); m != "family ff" {
+		t.Errorf("w1Model(ff) = %q", m)
+	}
+}
+
 func TestReadDHT22(t *testing.T) {
 	sensors := ReadDHT22("testdata/iio_device")
 
@@ -129,6 +163,9 @@ func TestReadDHT22(t *testing.T) {
 	if sensors[1].ID != "utility_room_humidity" || sensors[1].Value != "49.3" {
 		t.Errorf("humidity sensor = %+v, want id=utility_room_humidity value=49.3", sensors[1])
 	}
+	if sensors[0].Address != "iio_device" || sensors[0].Driver != "iio" || sensors[0].Model != "DHT22" {
+		t.Errorf("sensor = %+v, want iio DHT22 at iio_device", sensors[0])
+	}
 }
 
 func TestReadDHT22_NoDevice(t *testing.T) {
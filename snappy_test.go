@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// snappyDecode is a reference decoder for the Snappy block format,
+// used to verify the encoder and to inspect remote-write requests.
+func snappyDecode(src []byte) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 {
+		return nil, errors.New("bad length")
+	}
+	src = src[k:]
+	dst := make([]byte, 0, n)
+
+	for len(src) > 0 {
+		tag := src[0]
+		src = src[1:]
+		switch tag & 3 {
+		case 0:
+			length := int(tag >> 2)
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errors.New("short literal length")
+				}
+				length = 0
+				for i := 0; i < extra; i++ {
+					length |= int(src[i]) << (8 * i)
+				}
+				src = src[extra:]
+			}
+			length++
+			if len(src) < length {
+				return nil, errors.New("short literal")
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1:
+			if len(src) < 1 {
+				return nil, errors.New("short copy1")
+			}
+			length := 4 + int(tag>>2)&7
+			offset := int(tag&0xe0)<<3 | int(src[0])
+			src = src[1:]
+			if err := snappyCopy(&dst, offset, length); err != nil {
+				return nil, err
+			}
+		case 2:
+			if len(src) < 2 {
+				return nil, errors.New("short copy2")
+			}
+			length := int(tag>>2) + 1
+			offset := int(binary.LittleEndian.Uint16(src))
+			src = src[2:]
+			if err := snappyCopy(&dst, offset, length); err != nil {
+				return nil, err
+			}
+		case 3:
+			if len(src) < 4 {
+				return nil, errors.New("short copy4")
+			}
+			length := int(tag>>2) + 1
+			offset := int(binary.LittleEndian.Uint32(src))
+			src = src[4:]
+			if err := snappyCopy(&dst, offset, length); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if uint64(len(dst)) != n {
+		return nil, errors.New("length mismatch")
+	}
+	return dst, nil
+}
+
+func snappyCopy(dst *[]byte, offset, length int) error {
+	if offset <= 0 || offset > len(*dst) {
+		return errors.New("bad offset")
+	}
+	start := len(*dst) - offset
+	for i := 0; i < length; i++ {
+		*dst = append(*dst, (*dst)[start+i])
+	}
+	return nil
+}
+
+func TestSnappyRoundTrip(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("abc"),
+		[]byte("abcdabcdabcdabcd"),
+		[]byte(strings.Repeat("tempsensor_temperature_celsius", 100)),
+		bytes.Repeat([]byte{0}, 100000),
+	}
+	for _, in := range inputs {
+		enc := snappyEncode(in)
+		dec, err := snappyDecode(enc)
+		if err != nil {
+			t.Errorf("decode(%d bytes): %v", len(in), err)
+			continue
+		}
+		if !bytes.Equal(dec, in) {
+			t.Errorf("round trip of %d bytes mismatched", len(in))
+		}
+	}
+}
+
+func TestSnappyCompresses(t *testing.T) {
+	in := []byte(strings.Repeat("hot_water_middle 48.750\n", 50))
+	enc := snappyEncode(in)
+	if len(enc) >= len(in)/4 {
+		t.Errorf("encoded %d bytes to %d, expected real compression", len(in), len(enc))
+	}
+}
+
+func TestSnappyLongLiteral(t *testing.T) {
+	in := make([]byte, 70000)
+	for i := range in {
+		in[i] = byte(i*7 + i/251)
+	}
+	dec, err := snappyDecode(snappyEncode(in))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !bytes.Equal(dec, in) {
+		t.Error("round trip mismatched")
+	}
+}
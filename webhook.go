@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+const defaultWebhookTemplate = `{"time":{{json .Time}},"sensors":{{json .Sensors}}}`
+
+// webhookConfig is one entry of the WEBHOOK_CONFIG JSON file.
+type webhookConfig struct {
+	Name         string            `json:"name"`
+	URL          string            `json:"url"`
+	Method       string            `json:"method"`
+	Template     string            `json:"template"`
+	TemplateFile string            `json:"template_file"`
+	Headers      map[string]string `json:"headers"`
+	Secret       string            `json:"secret"`
+	OnChange     bool              `json:"on_change"`
+}
+
+// webhookData is the data a webhook body template is rendered with.
+type webhookData struct {
+	Time    time.Time
+	Sensors []Sensor
+	Changed []Sensor
+	ByID    map[string]Sensor
+}
+
+// webhookOutput POSTs a templated body to a URL on every poll, or
+// only when a value changed. If a secret is configured the body is
+// signed with HMAC-SHA256 in the X-Signature-256 header.
+type webhookOutput struct {
+	name     string
+	url      string
+	method   string
+	tmpl     *template.Template
+	headers  map[string]string
+	secret   string
+	onChange bool
+	client   *http.Client
+
+	last     map[string]string
+	failures int
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"float": func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	},
+}
+
+// LoadWebhooks reads a JSON array of webhook configs from path.
+func LoadWebhooks(path string) ([]*webhookOutput, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []webhookConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	var hooks []*webhookOutput
+	for i, cfg := range configs {
+		if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("webhook%d", i)
+		}
+		if cfg.TemplateFile != "" {
+			t, err := os.ReadFile(cfg.TemplateFile)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %s: %w", cfg.Name, err)
+			}
+			cfg.Template = string(t)
+		}
+		h, err := NewWebhook(cfg)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+func NewWebhook(cfg webhookConfig) (*webhookOutput, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook %s: missing url", cfg.Name)
+	}
+	if cfg.Template == "" {
+		cfg.Template = defaultWebhookTemplate
+	}
+	if cfg.Method == "" {
+		cfg.Method = "POST"
+	}
+	tmpl, err := template.New(cfg.Name).Funcs(webhookFuncs).Parse(cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s: %w", cfg.Name, err)
+	}
+
+	return &webhookOutput{
+		name:     cfg.Name,
+		url:      cfg.URL,
+		method:   cfg.Method,
+		tmpl:     tmpl,
+		headers:  cfg.Headers,
+		secret:   cfg.Secret,
+		onChange: cfg.OnChange,
+		last:     make(map[string]string),
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}, nil
+}
+
+func (h *webhookOutput) Name() string { return "webhook:" + h.name }
+
+func (h *webhookOutput) Push(sensors []Sensor) error {
+	data := webhookData{
+		Time:    time.Now().UTC(),
+		Sensors: sensors,
+		ByID:    make(map[string]Sensor, len(sensors)),
+	}
+	for _, s := range sensors {
+		data.ByID[s.ID] = s
+		if h.last[s.ID] != s.Value {
+			data.Changed = append(data.Changed, s)
+		}
+	}
+	if h.onChange && len(data.Changed) == 0 {
+		return nil
+	}
+
+	if err := h.send(data); err != nil {
+		h.failures++
+		if h.failures == 1 || h.failures%10 == 0 {
+			log.Printf("webhook %s: push failed (%d consecutive): %v",
+				h.name, h.failures, err)
+		}
+		return err
+	}
+
+	if h.failures > 0 {
+		log.Printf("webhook %s: recovered after %d failures", h.name, h.failures)
+		h.failures = 0
+	}
+	for _, s := range sensors {
+		h.last[s.ID] = s.Value
+	}
+	return nil
+}
+
+func (h *webhookOutput) send(data webhookData) error {
+	var body bytes.Buffer
+	if err := h.tmpl.Execute(&body, data); err != nil {
+		return fmt.Errorf("render template: %w", err)
+	}
+
+	req, err := http.NewRequest(h.method, h.url, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	if h.secret != "" {
+		req.Header.Set("X-Signature-256", "sha256="+signHMAC(h.secret, body.Bytes()))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func signHMAC(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type webhookRequest struct {
+	method  string
+	body    string
+	headers http.Header
+}
+
+func newWebhookReceiver(t *testing.T, status int) (*httptest.Server, *[]webhookRequest) {
+	var reqs []webhookRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		reqs = append(reqs, webhookRequest{r.Method, string(body), r.Header.Clone()})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &reqs
+}
+
+func TestWebhook_DefaultTemplate(t *testing.T) {
+	ts, reqs := newWebhookReceiver(t, http.StatusOK)
+
+	h, err := NewWebhook(webhookConfig{Name: "nodered", URL: ts.URL})
+	if err != nil {
+		t.Fatalf("NewWebhook: %v", err)
+	}
+	h.Push([]Sensor{{ID: "hot_water_middle", Value: "48.750"}})
+
+	if len(*reqs) != 1 {
+		t.Fatalf("requests = %d, want 1", len(*reqs))
+	}
+	req := (*reqs)[0]
+	if req.method != "POST" {
+		t.Errorf("method = %q, want POST", req.method)
+	}
+	var got struct {
+		Time    string   `json:"time"`
+		Sensors []Sensor `json:"sensors"`
+	}
+	if err := json.Unmarshal([]byte(req.body), &got); err != nil {
+		t.Fatalf("body %q: %v", req.body, err)
+	}
+	if len(got.Sensors) != 1 || got.Sensors[0].ID != "hot_water_middle" || got.Sensors[0].Value != "48.750" {
+		t.Errorf("sensors = %+v", got.Sensors)
+	}
+	if got.Time == "" {
+		t.Error("expected time in body")
+	}
+}
+
+func TestWebhook_CustomTemplateAndHeaders(t *testing.T) {
+	ts, reqs := newWebhookReceiver(t, http.StatusOK)
+
+	h, err := NewWebhook(webhookConfig{
+		URL:      ts.URL,
+		Method:   "PUT",
+		Template: `{"tank":{{float (index .ByID "hot_water_middle").Value}},"n":{{len .Sensors}}}`,
+		Headers:  map[string]string{"X-Api-Key": "k1", "Content-Type": "application/vnd.heating+json"},
+	})
+	if err != nil {
+		t.Fatalf("NewWebhook: %v", err)
+	}
+	h.Push([]Sensor{
+		{ID: "hot_water_middle", Value: "48.750"},
+		{ID: "heating_supply", Value: "42.500"},
+	})
+
+	req := (*reqs)[0]
+	if req.method != "PUT" {
+		t.Errorf("method = %q, want PUT", req.method)
+	}
+	if req.body != `{"tank":48.75,"n":2}` {
+		t.Errorf("body = %q", req.body)
+	}
+	if req.headers.Get("X-Api-Key") != "k1" {
+		t.Errorf("X-Api-Key = %q, want k1", req.headers.Get("X-Api-Key"))
+	}
+	if req.headers.Get("Content-Type") != "application/vnd.heating+json" {
+		t.Errorf("content-type = %q", req.headers.Get("Content-Type"))
+	}
+}
+
+func TestWebhook_HMACSignature(t *testing.T) {
+	ts, reqs := newWebhookReceiver(t, http.StatusOK)
+
+	h, _ := NewWebhook(webhookConfig{URL: ts.URL, Secret: "s3cret"})
+	h.Push([]Sensor{{ID: "0", Value: "21.000"}})
+
+	req := (*reqs)[0]
+	want := "sha256=" + signHMAC("s3cret", []byte(req.body))
+	if got := req.headers.Get("X-Signature-256"); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+}
+
+func TestWebhook_OnChange(t *testing.T) {
+	ts, reqs := newWebhookReceiver(t, http.StatusOK)
+
+	h, _ := NewWebhook(webhookConfig{
+		URL:      ts.URL,
+		OnChange: true,
+		Template: `{{range .Changed}}{{.ID}}={{.Value}};{{end}}`,
+	})
+	h.Push([]Sensor{{ID: "0", Value: "21.000"}, {ID: "1", Value: "22.000"}})
+	h.Push([]Sensor{{ID: "0", Value: "21.000"}, {ID: "1", Value: "22.000"}})
+	h.Push([]Sensor{{ID: "0", Value: "21.000"}, {ID: "1", Value: "22.500"}})
+
+	if len(*reqs) != 2 {
+		t.Fatalf("requests = %d, want 2 (unchanged poll skipped)", len(*reqs))
+	}
+	if (*reqs)[1].body != "1=22.500;" {
+		t.Errorf("second body = %q, want only the changed sensor", (*reqs)[1].body)
+	}
+}
+
+func TestWebhook_FailureCounting(t *testing.T) {
+	ts, _ := newWebhookReceiver(t, http.StatusBadGateway)
+
+	h, _ := NewWebhook(webhookConfig{URL: ts.URL, OnChange: true})
+	h.Push([]Sensor{{ID: "0", Value: "21.000"}})
+	h.Push([]Sensor{{ID: "0", Value: "21.000"}})
+
+	if h.failures != 2 {
+		t.Errorf("failures = %d, want 2 (unchanged values retried after failure)", h.failures)
+	}
+}
+
+func TestLoadWebhooks(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "body.tmpl")
+	os.WriteFile(tmplPath, []byte(`{{len .Sensors}}`), 0644)
+
+	cfgPath := filepath.Join(dir, "webhooks.json")
+	os.WriteFile(cfgPath, []byte(`[
+		{"name": "nodered", "url": "http://nodered:1880/heating"},
+		{"url": "http://controller/api", "template_file": "`+tmplPath+`", "on_change": true}
+	]`), 0644)
+
+	hooks, err := LoadWebhooks(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadWebhooks: %v", err)
+	}
+	if len(hooks) != 2 {
+		t.Fatalf("hooks = %d, want 2", len(hooks))
+	}
+	if hooks[0].name != "nodered" || hooks[1].name != "webhook1" {
+		t.Errorf("names = %q, %q", hooks[0].name, hooks[1].name)
+	}
+	if !hooks[1].onChange {
+		t.Error("expected on_change for second hook")
+	}
+}
+
+func TestLoadWebhooks_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"bad_json":     `{`,
+		"missing_url":  `[{"name": "x"}]`,
+		"bad_template": `[{"url": "http://x", "template": "{{.Nope"}]`,
+	}
+	for name, content := range cases {
+		path := filepath.Join(dir, name+".json")
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadWebhooks(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
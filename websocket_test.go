@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func wsPipe() (client, server *wsConn) {
+	a, b := net.Pipe()
+	return &wsConn{conn: a, br: bufio.NewReader(a), client: true},
+		&wsConn{conn: b, br: bufio.NewReader(b)}
+}
+
+func TestWSAcceptKey(t *testing.T) {
+	// Example from RFC 6455, section 1.3.
+	if got := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("accept key = %q", got)
+	}
+}
+
+func TestWSRoundTrip(t *testing.T) {
+	client, server := wsPipe()
+	defer client.Close()
+	defer server.Close()
+
+	sizes := []int{0, 5, 125, 126, 1000, 70000}
+	go func() {
+		for _, n := range sizes {
+			client.WriteMessage(wsText, bytes.Repeat([]byte("x"), n))
+		}
+	}()
+	for _, n := range sizes {
+		op, msg, err := server.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %d: %v", n, err)
+		}
+		if op != wsText || len(msg) != n {
+			t.Errorf("got opcode %d, %d bytes, want text, %d bytes", op, len(msg), n)
+		}
+	}
+
+	go server.WriteMessage(wsBinary, []byte("reply"))
+	op, msg, err := client.ReadMessage()
+	if err != nil || op != wsBinary || string(msg) != "reply" {
+		t.Errorf("client read = %d %q %v", op, msg, err)
+	}
+}
+
+func TestWSClientFramesMasked(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	client := &wsConn{conn: a, br: bufio.NewReader(a), client: true}
+
+	go client.WriteMessage(wsText, []byte("hello"))
+	hdr := make([]byte, 2)
+	if _, err := b.Read(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if hdr[1]&0x80 == 0 {
+		t.Error("client frame is not masked")
+	}
+}
+
+func TestWSFragmentsAndPing(t *testing.T) {
+	client, server := wsPipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		// Fragmented text message with a ping in between.
+		server.conn.Write([]byte{wsText, 3, 'a', 'b', 'c'})
+		server.conn.Write([]byte{0x80 | wsPing, 2, 'h', 'i'})
+		server.conn.Write([]byte{0x80 | wsContinuation, 3, 'd', 'e', 'f'})
+	}()
+
+	pong := make(chan string, 1)
+	go func() {
+		_, op, payload, err := server.readFrame()
+		if err == nil && op == wsPong {
+			pong <- string(payload)
+		}
+		close(pong)
+	}()
+
+	op, msg, err := client.ReadMessage()
+	if err != nil || op != wsText || string(msg) != "abcdef" {
+		t.Fatalf("read = %d %q %v, want reassembled abcdef", op, msg, err)
+	}
+	if got := <-pong; got != "hi" {
+		t.Errorf("pong payload = %q, want hi", got)
+	}
+}
+
+func TestWSClose(t *testing.T) {
+	client, server := wsPipe()
+	defer client.Close()
+	defer server.Close()
+
+	go server.WriteMessage(wsClose, []byte{0x03, 0xe8})
+	go func() {
+		// Drain the close acknowledgement.
+		server.readFrame()
+	}()
+	if _, _, err := client.ReadMessage(); err != errWSClosed {
+		t.Errorf("err = %v, want errWSClosed", err)
+	}
+}
+
+func TestDialWebSocket(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		_, msg, err := ws.ReadMessage()
+		if err == nil {
+			ws.WriteMessage(wsText, append([]byte("echo:"), msg...))
+		}
+	}))
+	defer ts.Close()
+
+	ws, err := dialWebSocket("ws"+strings.TrimPrefix(ts.URL, "http")+"/api/websocket", time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	ws.WriteMessage(wsText, []byte("hi"))
+	_, msg, err := ws.ReadMessage()
+	if err != nil || string(msg) != "echo:hi" {
+		t.Errorf("read = %q %v, want echo:hi", msg, err)
+	}
+}
+
+func TestDialWebSocket_Rejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	if _, err := dialWebSocket("ws"+strings.TrimPrefix(ts.URL, "http"), time.Second); err == nil {
+		t.Error("expected handshake error")
+	}
+	if _, err := dialWebSocket("ftp://example.com", time.Second); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
+
+func TestUpgradeWebSocket_Rejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ws, err := upgradeWebSocket(w, r); err == nil {
+			ws.Close()
+		}
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Upgrade") != "websocket" {
+		t.Errorf("plain GET: status = %d, Upgrade = %q", resp.StatusCode, resp.Header.Get("Upgrade"))
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("old version: status = %d", resp.StatusCode)
+	}
+}